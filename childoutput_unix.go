@@ -0,0 +1,163 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cybozu-go/log"
+)
+
+const (
+	defaultChildOutputBuffer = 1024
+
+	// maxChildLineSize limits the length of a line from a child.
+	// Longer lines are split.
+	maxChildLineSize = 1 << 20
+)
+
+// plainLogPattern matches the beginning of logs in log.PlainFormat.
+var plainLogPattern = regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ \S+ [a-z0-9]+: `)
+
+// childLine is a line read from stdout or stderr of a child process.
+type childLine struct {
+	stream string
+	data   []byte
+}
+
+// childOutput copies stdout and stderr of a child process to the logger.
+//
+// Lines are sent to the writer goroutine through a bounded channel
+// so that slow logging does not block the child.  If the channel is
+// full, lines are dropped.
+type childOutput struct {
+	logger *log.Logger
+	wrap   bool
+	fields map[string]interface{}
+
+	lines   chan childLine
+	dropped uint64
+}
+
+func newChildOutput(logger *log.Logger, wrap bool, size int, fields map[string]interface{}) *childOutput {
+	if size <= 0 {
+		size = defaultChildOutputBuffer
+	}
+	return &childOutput{
+		logger: logger,
+		wrap:   wrap,
+		fields: fields,
+		lines:  make(chan childLine, size),
+	}
+}
+
+// start starts copying stdout and stderr.  The returned channel is
+// closed when both reach EOF.
+func (o *childOutput) start(stdout, stderr io.Reader) <-chan struct{} {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		o.read("stdout", stdout)
+		wg.Done()
+	}()
+	go func() {
+		o.read("stderr", stderr)
+		wg.Done()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(o.lines)
+		close(done)
+	}()
+	go o.write()
+	return done
+}
+
+// read reads lines from r until EOF.
+func (o *childOutput) read(stream string, r io.Reader) {
+	br := bufio.NewReader(r)
+	var line []byte
+	for {
+		data, err := br.ReadSlice('\n')
+		line = append(line, data...)
+		if err == bufio.ErrBufferFull && len(line) < maxChildLineSize {
+			continue
+		}
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			o.send(childLine{stream: stream, data: line})
+			line = nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+func (o *childOutput) send(l childLine) {
+	select {
+	case o.lines <- l:
+	default:
+		atomic.AddUint64(&o.dropped, 1)
+	}
+}
+
+// write writes lines to the logger until the channel is closed.
+func (o *childOutput) write() {
+	for l := range o.lines {
+		o.writeLine(l)
+		// lines are dropped after those in the channel.
+		o.reportDropped()
+	}
+}
+
+func (o *childOutput) writeLine(l childLine) {
+	if !o.wrap || isFormattedLog(o.logger.Formatter(), l.data) {
+		o.logger.WriteThrough(l.data)
+		return
+	}
+
+	fields := make(map[string]interface{}, len(o.fields)+1)
+	for k, v := range o.fields {
+		fields[k] = v
+	}
+	fields["stream"] = l.stream
+	o.logger.Info(string(bytes.TrimRight(l.data, "\r\n")), fields)
+}
+
+func (o *childOutput) reportDropped() {
+	n := atomic.SwapUint64(&o.dropped, 0)
+	if n == 0 {
+		return
+	}
+	fields := make(map[string]interface{}, len(o.fields)+1)
+	for k, v := range o.fields {
+		fields[k] = v
+	}
+	fields["lines"] = n
+	o.logger.Warn("well: dropped child output", fields)
+}
+
+// isFormattedLog returns true if line seems to be formatted by f.
+// Lines are regarded as formatted for unknown formatters.
+func isFormattedLog(f log.Formatter, line []byte) bool {
+	switch f.(type) {
+	case log.PlainFormat, *log.PlainFormat:
+		return plainLogPattern.Match(line)
+	case log.Logfmt, *log.Logfmt:
+		return bytes.HasPrefix(line, []byte("topic="))
+	case log.JSONFormat, *log.JSONFormat:
+		return bytes.HasPrefix(line, []byte(`{"topic":`))
+	}
+	return true
+}
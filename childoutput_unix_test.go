@@ -0,0 +1,92 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cybozu-go/log"
+)
+
+func TestIsFormattedLog(t *testing.T) {
+	t.Parallel()
+
+	formatters := []log.Formatter{log.PlainFormat{}, log.Logfmt{}, log.JSONFormat{}}
+	for _, f := range formatters {
+		buf := new(bytes.Buffer)
+		logger := log.NewLogger()
+		logger.SetFormatter(f)
+		logger.SetOutput(buf)
+		logger.Info("hello", map[string]interface{}{"foo": 1})
+
+		if !isFormattedLog(f, buf.Bytes()) {
+			t.Errorf("%s: should be formatted: %s", f, buf.String())
+		}
+		if isFormattedLog(f, []byte("hello world\n")) {
+			t.Errorf("%s: should not be formatted", f)
+		}
+	}
+
+	if !isFormattedLog(log.MsgPack{}, []byte("hello world\n")) {
+		t.Error("unknown formats should be regarded as formatted")
+	}
+}
+
+func TestChildOutput(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	logger := log.NewLogger()
+	logger.SetFormatter(log.Logfmt{})
+	logger.SetOutput(buf)
+
+	formatted := "topic=foo logged_at=2026-01-01T00:00:00.000000Z severity=info utsname=bar message=hello\n"
+	o := newChildOutput(logger, true, 10, map[string]interface{}{"child_pid": 123})
+	o.read("stdout", strings.NewReader("plain text\n"+formatted+"no newline"))
+	close(o.lines)
+	o.write()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatal("unexpected output:", buf.String())
+	}
+	if !strings.Contains(lines[0], `message="plain text"`) ||
+		!strings.Contains(lines[0], "child_pid=123") ||
+		!strings.Contains(lines[0], `stream="stdout"`) {
+		t.Error("unexpected wrapped line:", lines[0])
+	}
+	if lines[1]+"\n" != formatted {
+		t.Error("formatted line should be written as is:", lines[1])
+	}
+	if !strings.Contains(lines[2], `message="no newline"`) {
+		t.Error("unexpected wrapped line:", lines[2])
+	}
+
+	buf.Reset()
+	o = newChildOutput(logger, false, 1, nil)
+	o.read("stderr", strings.NewReader("a\nb\nc\n"))
+	close(o.lines)
+	o.write()
+
+	lines = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != "a" {
+		t.Fatal("unexpected output:", buf.String())
+	}
+	if !strings.Contains(lines[1], "dropped child output") || !strings.Contains(lines[1], "lines=2") {
+		t.Error("dropped lines should be reported:", lines[1])
+	}
+
+	buf.Reset()
+	o = newChildOutput(logger, false, 10, nil)
+	o.read("stderr", strings.NewReader(strings.Repeat("x", maxChildLineSize+10)))
+	close(o.lines)
+	o.write()
+
+	lines = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || len(lines[0]) < maxChildLineSize || len(lines[1]) > 4096 {
+		t.Error("long line should be split:", len(lines))
+	}
+}
@@ -0,0 +1,132 @@
+package well
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Commands accepted by the control socket of Graceful.
+const (
+	controlStatus     = "status"
+	controlRestart    = "restart"
+	controlStop       = "stop"
+	controlReopenLogs = "reopen-logs"
+)
+
+// MasterStatus represents the status of the master process of Graceful.
+type MasterStatus struct {
+	// PID is the process ID of the master.
+	PID int `json:"pid"`
+
+	// StartAt is the time when the master was started.
+	StartAt time.Time `json:"start_at"`
+
+	// Uptime is the seconds elapsed since StartAt.
+	Uptime float64 `json:"uptime"`
+
+	// Restarts is the number of completed restarts of all children
+	// by SIGHUP or the restart command.
+	Restarts int `json:"restarts"`
+
+	// Children is the status of child processes.
+	Children []ChildStatus `json:"children"`
+}
+
+// ControlResponse is a response from the control socket of Graceful.
+type ControlResponse struct {
+	// Error is not empty if the command failed.
+	Error string `json:"error,omitempty"`
+
+	// Status is the status of the master after the command.
+	// This is nil for the reopen-logs command.
+	Status *MasterStatus `json:"status,omitempty"`
+}
+
+// ControlClient is a client for the control socket of Graceful.
+// See Graceful.ControlSocket.
+type ControlClient struct {
+	// Path is the path of the control socket.
+	Path string
+}
+
+// Do sends command to the master process and returns the response.
+// If the command fails, this returns the response with an error.
+//
+// The deadline of ctx is applied to the whole exchange.
+func (c *ControlClient) Do(ctx context.Context, command string) (*ControlResponse, error) {
+	if len(command) == 0 || strings.ContainsAny(command, "\r\n") {
+		return nil, errors.New("invalid command: " + command)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	_, err = io.WriteString(conn, command+"\n")
+	if err == nil {
+		resp := new(ControlResponse)
+		err = json.NewDecoder(conn).Decode(resp)
+		if err == nil {
+			if len(resp.Error) > 0 {
+				return resp, errors.New(resp.Error)
+			}
+			return resp, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, err
+}
+
+// Status returns the status of the master process.
+func (c *ControlClient) Status(ctx context.Context) (*MasterStatus, error) {
+	resp, err := c.Do(ctx, controlStatus)
+	if resp == nil {
+		return nil, err
+	}
+	return resp.Status, err
+}
+
+// Restart restarts child processes in the same way as SIGHUP,
+// and waits for the restart to complete.  This returns an error
+// if a new child fails to become ready, together with the status
+// of the master.
+func (c *ControlClient) Restart(ctx context.Context) (*MasterStatus, error) {
+	resp, err := c.Do(ctx, controlRestart)
+	if resp == nil {
+		return nil, err
+	}
+	return resp.Status, err
+}
+
+// Stop makes the master process cancel its environment.
+// This returns without waiting for the master to exit.
+func (c *ControlClient) Stop(ctx context.Context) error {
+	_, err := c.Do(ctx, controlStop)
+	return err
+}
+
+// ReopenLogs makes the master process reopen its log files.
+func (c *ControlClient) ReopenLogs(ctx context.Context) error {
+	_, err := c.Do(ctx, controlReopenLogs)
+	return err
+}
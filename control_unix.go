@@ -0,0 +1,202 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+const (
+	controlEnv = "CYBOZU_CONTROL_FD"
+)
+
+// controlRequest is a command to be executed in the main loop of
+// the master process.
+type controlRequest struct {
+	command string
+	result  chan error
+}
+
+// listenControl listens on the control socket at path.
+// A new master started by ActionUpgrade inherits the socket from
+// the old master instead.
+//
+// The returned listener does not remove the socket file on Close.
+// The caller needs to remove it.
+func listenControl(path string) (*net.UnixListener, error) {
+	if v := os.Getenv(controlEnv); len(v) > 0 {
+		os.Unsetenv(controlEnv)
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		f := os.NewFile(uintptr(fd), path)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		ul, ok := ln.(*net.UnixListener)
+		if !ok {
+			ln.Close()
+			return nil, errors.New("control socket is not a unix socket")
+		}
+		return ul, nil
+	}
+
+	err := removeStaleControl(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is created in a private directory, and moved to path
+	// after its permission is set.  Otherwise, other users could
+	// connect to the socket created with the process umask.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ctl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleControl removes the socket at path left by a crashed master.
+// It returns an error if another process is listening on the socket.
+func removeStaleControl(path string) error {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	switch {
+	case err == nil:
+		conn.Close()
+		return errors.New("control socket is in use: " + path)
+	case errors.Is(err, syscall.ENOENT):
+		return nil
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return os.Remove(path)
+	}
+	return err
+}
+
+// serveControl accepts connections to the control socket until
+// ln is closed.
+func (m *master) serveControl(ln *net.UnixListener) {
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			log.Debug("well: control socket accept error", map[string]interface{}{
+				log.FnError: err.Error(),
+			})
+			return
+		}
+		go m.handleControl(conn)
+	}
+}
+
+// handleControl reads commands line by line from conn, and writes
+// a JSON response for each.
+func (m *master) handleControl(conn *net.UnixConn) {
+	defer conn.Close()
+
+	sc := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		command := sc.Text()
+		log.Info("well: got control command", map[string]interface{}{
+			"command": command,
+		})
+		err := enc.Encode(m.runControl(command))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runControl executes command and returns the response.
+func (m *master) runControl(command string) *ControlResponse {
+	var err error
+	switch command {
+	case controlStatus:
+	case controlRestart:
+		err = m.request(command)
+	case controlStop:
+		env := m.g.Env
+		if env == nil {
+			env = defaultEnv
+		}
+		env.Cancel(nil)
+	case controlReopenLogs:
+		resp := &ControlResponse{}
+		if err := reopenLogFiles(); err != nil {
+			resp.Error = err.Error()
+		}
+		return resp
+	default:
+		return &ControlResponse{Error: "unknown command: " + command}
+	}
+
+	resp := &ControlResponse{Status: m.status()}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// request asks the main loop of the master process to execute
+// command, and waits for the result.
+func (m *master) request(command string) error {
+	req := &controlRequest{
+		command: command,
+		result:  make(chan error, 1),
+	}
+	select {
+	case m.controlCh <- req:
+	case <-m.quit:
+		return errors.New("master is stopping")
+	}
+	select {
+	case err := <-req.result:
+		return err
+	case <-m.quit:
+		return errors.New("master is stopping")
+	}
+}
+
+// status returns the status of the master process.
+func (m *master) status() *MasterStatus {
+	m.mu.Lock()
+	restarts := m.restarts
+	m.mu.Unlock()
+
+	return &MasterStatus{
+		PID:      os.Getpid(),
+		StartAt:  m.startAt,
+		Uptime:   time.Since(m.startAt).Seconds(),
+		Restarts: restarts,
+		Children: m.children(),
+	}
+}
@@ -0,0 +1,147 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestControlSocket(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Error("unexpected permission:", fi.Mode().Perm())
+	}
+
+	m := newMaster(&Graceful{Workers: 2}, nil, nil, 0)
+	defer close(m.quit)
+	go m.serveControl(ln)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := &ControlClient{Path: path}
+
+	st, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.PID != os.Getpid() {
+		t.Error("unexpected pid:", st.PID)
+	}
+	if len(st.Children) != 2 || st.Children[1].Index != 1 || st.Children[1].State != "crashed" {
+		t.Error("unexpected children:", st.Children)
+	}
+
+	restartErr := errors.New("child exited before ready")
+	go func() {
+		req := <-m.controlCh
+		if req.command != controlRestart {
+			t.Error("unexpected command:", req.command)
+		}
+		req.result <- nil
+		req = <-m.controlCh
+		req.result <- restartErr
+	}()
+	st, err = c.Restart(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil {
+		t.Fatal("no status")
+	}
+	st, err = c.Restart(ctx)
+	if err == nil || err.Error() != restartErr.Error() {
+		t.Error("restart should fail:", err)
+	}
+	if st == nil {
+		t.Error("failed restart should return status")
+	}
+
+	err = c.ReopenLogs(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = c.Do(ctx, "foo")
+	if err == nil {
+		t.Error("unknown command should fail")
+	}
+	_, err = c.Do(ctx, "status\nstop")
+	if err == nil {
+		t.Error("invalid command should fail")
+	}
+}
+
+func TestListenControlInUse(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = listenControl(path)
+	if err == nil {
+		t.Fatal("socket in use should not be removed")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// leave the socket as a crashed master.
+	ln.Close()
+
+	ln, err = listenControl(path)
+	if err != nil {
+		t.Fatal("stale socket should be removed:", err)
+	}
+	ln.Close()
+}
+
+func TestListenControlPermission(t *testing.T) {
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "control.sock")
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Error("unexpected permission:", fi.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Error("temporary directory should be removed:", len(entries))
+	}
+}
@@ -0,0 +1,227 @@
+package well
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule determines when a Job runs.
+type Schedule interface {
+	// Next returns the next time after t.
+	// Zero time means no more runs.
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// Every returns a Schedule that runs a Job at a fixed interval.
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+	return intervalSchedule(interval)
+}
+
+// cronSchedule is a parsed cron expression.
+// Each field is a bit set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// true if dom or dow is "*".
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseCron parses a cron expression and returns a Schedule.
+//
+// The expression consists of five fields: minute, hour, day of month,
+// month, and day of week.  Each field accepts "*", a number, a range
+// "a-b", a step "*/n" or "a-b/n", and a comma-separated list of them.
+// Month and day of week also accept three-letter English names.
+// Both 0 and 7 mean Sunday.
+//
+// As with the traditional cron, if both day of month and day of week
+// are restricted, a time matches when either field matches.
+//
+// The following descriptors are also accepted:
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight, and @hourly.
+//
+// Times are computed in the location of the time passed to Next.
+func ParseCron(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("cron: expected 5 fields: " + spec)
+	}
+
+	s := &cronSchedule{}
+	var err error
+	if s.minute, err = cronMinute.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = cronHour.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = cronDom.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = cronMonth.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = cronDow.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return s, nil
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		b, err := f.parseExpr(expr)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+func (f cronField) parseExpr(expr string) (uint64, error) {
+	rangeExpr := expr
+	step := 1
+	if i := strings.IndexByte(expr, '/'); i >= 0 {
+		n, err := strconv.Atoi(expr[i+1:])
+		if err != nil || n <= 0 {
+			return 0, errors.New("cron: invalid step: " + expr)
+		}
+		rangeExpr = expr[:i]
+		step = n
+	}
+
+	var start, end int
+	switch {
+	case rangeExpr == "*":
+		start, end = f.min, f.max
+	case strings.IndexByte(rangeExpr, '-') >= 0:
+		i := strings.IndexByte(rangeExpr, '-')
+		var err error
+		if start, err = f.value(rangeExpr[:i]); err != nil {
+			return 0, err
+		}
+		if end, err = f.value(rangeExpr[i+1:]); err != nil {
+			return 0, err
+		}
+		if start > end {
+			return 0, errors.New("cron: invalid range: " + expr)
+		}
+	default:
+		var err error
+		if start, err = f.value(rangeExpr); err != nil {
+			return 0, err
+		}
+		end = start
+		if step > 1 {
+			end = f.max
+		}
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("cron: invalid value: " + s)
+	}
+	if n < f.min || n > f.max {
+		return 0, errors.New("cron: value out of range: " + s)
+	}
+	return n, nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next implements Schedule.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// cron expressions repeat at least every 4 years (Feb 29).
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
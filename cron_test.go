@@ -0,0 +1,65 @@
+package well
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2023, 1, 31, 10, 15, 30, 0, time.UTC)
+
+	cases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2023, 1, 31, 10, 16, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2023, 1, 31, 10, 20, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2023, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2023, 2, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * mon-fri", time.Date(2023, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2023, 2, 5, 12, 0, 0, 0, time.UTC)},
+		{"0 0 15 * sat", time.Date(2023, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"5,10 10-11/1 * Jan *", time.Date(2023, 1, 31, 11, 5, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, 1, 31, 11, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		s, err := ParseCron(c.spec)
+		if err != nil {
+			t.Error(c.spec, err)
+			continue
+		}
+		next := s.Next(base)
+		if !next.Equal(c.expected) {
+			t.Error(c.spec, `!next.Equal(c.expected)`, next)
+		}
+	}
+}
+
+func TestParseCronError(t *testing.T) {
+	t.Parallel()
+
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	}
+	for _, spec := range specs {
+		_, err := ParseCron(spec)
+		if err == nil {
+			t.Error(`err == nil`, spec)
+		}
+	}
+}
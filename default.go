@@ -1,6 +1,9 @@
 package well
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 var (
 	defaultEnv *Environment
@@ -8,8 +11,28 @@ var (
 
 func init() {
 	defaultEnv = NewEnvironment(context.Background())
-	handleSignal(defaultEnv)
+	handleSignal()
+	handleDumpSignal()
 	handleSigPipe()
+	handleWatchdog()
+}
+
+// EnablePanicRecovery makes goroutines in the global environment
+// recover from panics.  See Environment.EnablePanicRecovery.
+func EnablePanicRecovery() {
+	defaultEnv.EnablePanicRecovery()
+}
+
+// NewChild creates a child environment of the global environment.
+// See Environment.NewChild.
+func NewChild(name string, propagateCancel bool) *Environment {
+	return defaultEnv.NewChild(name, propagateCancel)
+}
+
+// EnableErrorAggregation makes Wait return errors from all goroutines
+// in the global environment.  See Environment.EnableErrorAggregation.
+func EnableErrorAggregation() {
+	defaultEnv.EnableErrorAggregation()
 }
 
 // Stop just declares no further Go will be called.
@@ -43,7 +66,11 @@ func Cancel(err error) bool {
 // The returned err is the one passed to Cancel, or nil.
 // err can be tested by IsSignaled to determine whether the
 // program got SIGINT or SIGTERM.
+//
+// If EnableAutoReady has been called, this sends READY=1 to systemd
+// before waiting.
 func Wait() error {
+	notifyAutoReady()
 	return defaultEnv.Wait()
 }
 
@@ -68,3 +95,64 @@ func Go(f func(ctx context.Context) error) {
 func GoWithID(f func(ctx context.Context) error) {
 	defaultEnv.GoWithID(f)
 }
+
+// GoWithName starts a named goroutine in the global environment.
+// See Environment.GoWithName.
+func GoWithName(name string, labels map[string]string, f func(ctx context.Context) error) {
+	defaultEnv.GoWithName(name, labels, f)
+}
+
+// Tasks returns the list of goroutines that are currently running
+// in the global environment.
+func Tasks() []Task {
+	return defaultEnv.Tasks()
+}
+
+// Supervise starts a supervised goroutine in the global environment.
+// See Environment.Supervise.
+func Supervise(name string, policy RestartPolicy, f func(ctx context.Context) error) {
+	defaultEnv.Supervise(name, policy, f)
+}
+
+// OnShutdown registers a hook function to the given shutdown phase
+// of the global environment.  See Environment.OnShutdown.
+func OnShutdown(phase ShutdownPhase, name string, f func(ctx context.Context) error) {
+	defaultEnv.OnShutdown(phase, name, f)
+}
+
+// SetPhaseTimeout sets the maximum duration of the given shutdown
+// phase of the global environment.  See Environment.SetPhaseTimeout.
+func SetPhaseTimeout(phase ShutdownPhase, timeout time.Duration) {
+	defaultEnv.SetPhaseTimeout(phase, timeout)
+}
+
+// GoInPhase starts a named goroutine in the global environment that
+// runs until the given shutdown phase begins.
+// See Environment.GoInPhase.
+func GoInPhase(phase ShutdownPhase, name string, labels map[string]string, f func(ctx context.Context) error) {
+	defaultEnv.GoInPhase(phase, name, labels, f)
+}
+
+// NewWorkerPool creates a WorkerPool in the global environment.
+// See Environment.NewWorkerPool.
+func NewWorkerPool(name string, workers, queueSize int) *WorkerPool {
+	return defaultEnv.NewWorkerPool(name, workers, queueSize)
+}
+
+// OnReload registers a callback function to the global environment
+// that is called on SIGHUP.  See Environment.OnReload.
+func OnReload(name string, f func(ctx context.Context) error) {
+	defaultEnv.OnReload(name, f)
+}
+
+// Reload calls the reload callbacks of the global environment.
+// See Environment.Reload.
+func Reload() error {
+	return defaultEnv.Reload()
+}
+
+// AddHealthCheck registers a function to check the health of
+// the program to the global environment.  See Environment.AddHealthCheck.
+func AddHealthCheck(name string, f func(ctx context.Context) error) {
+	defaultEnv.AddHealthCheck(name, f)
+}
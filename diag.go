@@ -0,0 +1,112 @@
+package well
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+const (
+	diagnosticsDumpEnv = "DIAGNOSTICS_DUMP"
+)
+
+var (
+	dumpMu  sync.Mutex
+	dumpDir string
+)
+
+// EnableDiagnosticsDump enables diagnostics dump by SIGUSR2.
+// The signal can be changed by HandleSignal with ActionDump.
+//
+// Diagnostics consist of the stack traces of all goroutines, the heap
+// profile, and the list of goroutines in the global environment.
+//
+// If dir is not empty, diagnostics are written to files in dir.
+// Otherwise, they are written to the default logger except for the
+// heap profile.  In that case, memory statistics are logged instead.
+//
+// The program does not stop after dumping diagnostics.
+func EnableDiagnosticsDump(dir string) {
+	dumpMu.Lock()
+	dumpDir = dir
+	dumpMu.Unlock()
+
+	if dumpSignal != nil {
+		setDefaultSignal(dumpSignal, ActionDump)
+	}
+}
+
+// handleDumpSignal enables diagnostics dump if DIAGNOSTICS_DUMP
+// environment variable is set.  The value "log" makes diagnostics
+// be written to the log.  Other values are used as the directory.
+func handleDumpSignal() {
+	dir, ok := os.LookupEnv(diagnosticsDumpEnv)
+	if !ok || len(dir) == 0 {
+		return
+	}
+	if dir == "log" {
+		dir = ""
+	}
+	EnableDiagnosticsDump(dir)
+}
+
+// dumpDiagnostics dumps diagnostics to files in dir, or to the log
+// if dir is empty.
+func dumpDiagnostics(env *Environment, dir string) error {
+	stacks := goroutineStacks()
+	tasks, err := json.Marshal(env.Tasks())
+	if err != nil {
+		return err
+	}
+
+	if len(dir) == 0 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return log.Info("well: diagnostics", map[string]interface{}{
+			"goroutines":     string(stacks),
+			"num_goroutines": runtime.NumGoroutine(),
+			"tasks":          string(tasks),
+			"heap_alloc":     ms.HeapAlloc,
+			"heap_inuse":     ms.HeapInuse,
+			"heap_objects":   ms.HeapObjects,
+			"num_gc":         ms.NumGC,
+		})
+	}
+
+	prefix := filepath.Join(dir, "well-"+strconv.Itoa(os.Getpid())+"-"+time.Now().UTC().Format("20060102T150405.000"))
+
+	err = os.WriteFile(prefix+".goroutines.txt", stacks, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(prefix+".tasks.json", tasks, 0644)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(prefix + ".heap.pprof")
+	if err != nil {
+		return err
+	}
+	err = pprof.Lookup("heap").WriteTo(f, 0)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	log.Info("well: dumped diagnostics", map[string]interface{}{
+		"prefix": prefix,
+	})
+	return nil
+}
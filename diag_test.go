@@ -0,0 +1,51 @@
+package well
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	env.GoWithName("worker", nil, func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	defer func() {
+		env.Cancel(nil)
+		env.Wait()
+	}()
+
+	dir := t.TempDir()
+	err := dumpDiagnostics(env, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, suffix := range []string{".goroutines.txt", ".tasks.json", ".heap.pprof"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 {
+			t.Error(`len(matches) != 1`, suffix)
+			continue
+		}
+		fi, err := os.Stat(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() == 0 {
+			t.Error(`fi.Size() == 0`, suffix)
+		}
+	}
+
+	err = dumpDiagnostics(env, "")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"os"
+	"syscall"
+)
+
+var dumpSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,5 @@
+package well
+
+import "os"
+
+var dumpSignal os.Signal
@@ -2,23 +2,65 @@ package well
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
+)
 
-	"github.com/cybozu-go/log"
+var (
+	// waitLogInterval is the interval to log the names of running
+	// goroutines while Wait is blocked.
+	waitLogInterval = 10 * time.Second
 )
 
+// Task describes a goroutine running in an Environment.
+type Task struct {
+	// Name is the name given to GoWithName or GoInPhase.
+	// Empty for goroutines started by Go or GoWithID.
+	Name string `json:"name"`
+
+	// Labels is the labels given to GoWithName or GoInPhase.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// StartAt is the time when the goroutine was started.
+	StartAt time.Time `json:"start_at"`
+
+	// RequestID is the request tracking ID of the goroutine, if any.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Phase is the shutdown phase in which the goroutine is canceled.
+	Phase ShutdownPhase `json:"phase"`
+}
+
 // Environment implements context-based goroutine management.
 type Environment struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
-	wg        sync.WaitGroup
 	generator *IDGenerator
 
+	phases       [numShutdownPhases]shutdownPhase
+	shutdownOnce sync.Once
+
 	mu       sync.RWMutex
 	stopped  bool
 	stopCh   chan struct{}
 	canceled bool
 	err      error
+
+	// for error aggregation
+	aggregate bool
+	errByTask bool
+	errs      []error
+
+	lastTaskID uint64
+	tasks      map[uint64]*Task
+
+	recoverPanic bool
+	reloadHooks  []reloadHook
+	healthChecks []healthCheck
+
+	// parent is set only when Cancel should be propagated.
+	parent *Environment
 }
 
 // NewEnvironment creates a new Environment.
@@ -27,16 +69,89 @@ type Environment struct {
 // for new environments.  Only the global environment will be
 // canceled on these signals.
 func NewEnvironment(ctx context.Context) *Environment {
-	ctx, cancel := context.WithCancel(ctx)
+	base, cancel := context.WithCancel(ctx)
 	e := &Environment{
-		ctx:       ctx,
+		ctx:       base,
 		cancel:    cancel,
 		generator: NewIDGenerator(),
 		stopCh:    make(chan struct{}),
+		tasks:     make(map[uint64]*Task),
+	}
+
+	e.phases[PhaseStopAccepting].ctx = base
+	e.phases[PhaseStopAccepting].cancel = cancel
+	for i := 1; i < numShutdownPhases; i++ {
+		e.phases[i].ctx, e.phases[i].cancel = context.WithCancel(ctx)
 	}
 	return e
 }
 
+// EnablePanicRecovery makes goroutines started by Go and its variants
+// recover from panics.
+//
+// A recovered panic is logged with its stack trace, and converted
+// into *PanicError that is passed to Cancel.  Therefore, the other
+// goroutines can stop gracefully and Wait returns the error.
+// The error can be tested by IsPanic.
+func (e *Environment) EnablePanicRecovery() {
+	e.mu.Lock()
+	e.recoverPanic = true
+	e.mu.Unlock()
+}
+
+// EnableErrorAggregation makes Wait return an error that contains
+// every non-nil error returned from goroutines started by Go and its
+// variants, in addition to the one passed to Cancel.
+//
+// Errors from goroutines are wrapped in *TaskError to tell which
+// goroutine returned the error.  The returned error can be inspected
+// by errors.Is and errors.As.
+func (e *Environment) EnableErrorAggregation() {
+	e.mu.Lock()
+	e.aggregate = true
+	e.mu.Unlock()
+}
+
+// NewChild creates a child environment of e.
+//
+// The base context of the child is derived from that of e, so
+// canceling e also cancels the child.  Wait of e waits for Wait of
+// the child to return.  The child appears in Tasks of e as name.
+//
+// Canceling the child does not cancel e unless propagateCancel is true.
+// If propagateCancel is true, non-nil error passed to Cancel of the
+// child is also passed to Cancel of e.
+//
+// If e has already been stopped, the returned child is canceled.
+func (e *Environment) NewChild(name string, propagateCancel bool) *Environment {
+	c := NewEnvironment(e.ctx)
+
+	e.mu.RLock()
+	c.recoverPanic = e.recoverPanic
+	c.aggregate = e.aggregate
+	e.mu.RUnlock()
+	if propagateCancel {
+		c.parent = e
+	}
+
+	started := e.goTask(&Task{Name: name}, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			e.mu.RLock()
+			err := e.err
+			e.mu.RUnlock()
+			c.Cancel(err)
+		case <-c.stopCh:
+		}
+		c.Wait()
+		return nil
+	})
+	if !started {
+		c.Cancel(nil)
+	}
+	return c
+}
+
 // Stop just declares no further Go will be called.
 //
 // Calling Stop is optional if and only if Cancel is guaranteed
@@ -66,43 +181,96 @@ func (e *Environment) Stop() {
 // This returns true if the caller is the first that calls Cancel.
 // For second and later calls, Cancel does nothing and returns false.
 func (e *Environment) Cancel(err error) bool {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	return e.cancelBy(nil, err)
+}
 
+// cancelBy cancels e with err.  t is the goroutine that returned err,
+// or nil if err is not returned from a goroutine.
+func (e *Environment) cancelBy(t *Task, err error) bool {
+	e.mu.Lock()
+	if t != nil && e.aggregate {
+		e.errs = append(e.errs, &TaskError{
+			Name:      t.Name,
+			RequestID: t.RequestID,
+			Err:       err,
+		})
+	}
 	if e.canceled {
+		e.mu.Unlock()
 		return false
 	}
 	e.canceled = true
 	e.err = err
+	e.errByTask = t != nil
 	e.cancel()
 
-	if e.stopped {
-		return true
+	if !e.stopped {
+		e.stopped = true
+		close(e.stopCh)
 	}
+	parent := e.parent
+	e.mu.Unlock()
 
-	e.stopped = true
-	close(e.stopCh)
+	if e == defaultEnv {
+		notifyStopping()
+	}
+	if parent != nil && err != nil {
+		parent.Cancel(err)
+	}
 	return true
 }
 
 // Wait waits for Stop or Cancel, and for all goroutines started by
 // Go to finish.
 //
+// Precisely, Wait executes the shutdown phases in order.
+// See ShutdownPhase for details.
+//
 // The returned err is the one passed to Cancel, or nil.
 // err can be tested by IsSignaled to determine whether the
 // program got SIGINT or SIGTERM.
+//
+// If error aggregation is enabled, the returned err also contains
+// errors returned from all goroutines.  See EnableErrorAggregation.
+//
+// While waiting for goroutines, Wait periodically logs the names
+// of goroutines that are still running.
 func (e *Environment) Wait() error {
 	<-e.stopCh
-	if log.Enabled(log.LvDebug) {
-		log.Debug("well: waiting for all goroutines to complete", nil)
-	}
-	e.wg.Wait()
-	e.cancel() // in case no one calls Cancel
+	e.shutdownOnce.Do(e.shutdown)
 
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	return e.err
+	if !e.aggregate {
+		return e.err
+	}
+
+	var errs []error
+	if e.err != nil && !e.errByTask {
+		errs = append(errs, e.err)
+	}
+	errs = append(errs, e.errs...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &joinError{errs}
+}
+
+// Tasks returns the list of goroutines that are currently running
+// in the environment.  The list is sorted by StartAt.
+func (e *Environment) Tasks() []Task {
+	e.mu.RLock()
+	tasks := make([]Task, 0, len(e.tasks))
+	for _, t := range e.tasks {
+		tasks = append(tasks, *t)
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartAt.Before(tasks[j].StartAt)
+	})
+	return tasks
 }
 
 // Go starts a goroutine that executes f.
@@ -119,28 +287,69 @@ func (e *Environment) Wait() error {
 // f should watch ctx.Done() channel and return quickly when the
 // channel is closed.
 func (e *Environment) Go(f func(ctx context.Context) error) {
-	e.mu.RLock()
+	e.goTask(&Task{}, f)
+}
+
+// GoWithID calls Go with a context having a new request tracking ID.
+func (e *Environment) GoWithID(f func(ctx context.Context) error) {
+	id := e.generator.Generate()
+	e.goTask(&Task{RequestID: id}, func(ctx context.Context) error {
+		return f(WithRequestID(ctx, id))
+	})
+}
+
+// GoWithName is similar to GoWithID but gives the goroutine a name
+// and optional labels.  They are reported by Tasks and logged by Wait
+// while the goroutine is running.
+//
+// labels may be nil.
+func (e *Environment) GoWithName(name string, labels map[string]string, f func(ctx context.Context) error) {
+	e.GoInPhase(PhaseStopAccepting, name, labels, f)
+}
+
+func (e *Environment) goTask(t *Task, f func(ctx context.Context) error) bool {
+	e.mu.Lock()
 	if e.stopped {
-		e.mu.RUnlock()
-		return
+		e.mu.Unlock()
+		return false
 	}
-	e.wg.Add(1)
-	e.mu.RUnlock()
+	p := &e.phases[t.Phase]
+	p.wg.Add(1)
+	e.lastTaskID++
+	tid := e.lastTaskID
+	t.StartAt = time.Now()
+	if len(t.RequestID) == 0 {
+		if v := e.ctx.Value(RequestIDContextKey); v != nil {
+			t.RequestID = v.(string)
+		}
+	}
+	e.tasks[tid] = t
+	recoverPanic := e.recoverPanic
+	e.mu.Unlock()
 
 	go func() {
-		ctx, cancel := context.WithCancel(e.ctx)
+		ctx, cancel := context.WithCancel(p.ctx)
 		defer cancel()
-		err := f(ctx)
+		err := runTask(ctx, t, recoverPanic, f)
 		if err != nil {
-			e.Cancel(err)
+			e.cancelBy(t, err)
 		}
-		e.wg.Done()
+
+		e.mu.Lock()
+		delete(e.tasks, tid)
+		e.mu.Unlock()
+		p.wg.Done()
 	}()
+	return true
 }
 
-// GoWithID calls Go with a context having a new request tracking ID.
-func (e *Environment) GoWithID(f func(ctx context.Context) error) {
-	e.Go(func(ctx context.Context) error {
-		return f(WithRequestID(ctx, e.generator.Generate()))
-	})
+func runTask(ctx context.Context, t *Task, recoverPanic bool, f func(ctx context.Context) error) (err error) {
+	if recoverPanic {
+		defer func() {
+			if v := recover(); v != nil {
+				err = newPanicError(t, v)
+			}
+		}()
+	}
+	return f(ctx)
 }
@@ -121,3 +121,133 @@ func TestEnvironmentID(t *testing.T) {
 		t.Error(`len(sid) != 36`)
 	}
 }
+
+func TestEnvironmentTasks(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	startCh := make(chan struct{})
+	waitCh := make(chan struct{})
+	env.GoWithName("worker", map[string]string{"kind": "test"}, func(ctx context.Context) error {
+		close(startCh)
+		<-waitCh
+		return nil
+	})
+	env.Go(func(ctx context.Context) error {
+		<-waitCh
+		return nil
+	})
+
+	<-startCh
+	tasks := env.Tasks()
+	if len(tasks) != 2 {
+		t.Fatal(`len(tasks) != 2`, len(tasks))
+	}
+
+	var named *Task
+	for i := range tasks {
+		if tasks[i].Name == "worker" {
+			named = &tasks[i]
+		}
+	}
+	if named == nil {
+		t.Fatal(`named task not found`)
+	}
+	if named.Labels["kind"] != "test" {
+		t.Error(`named.Labels["kind"] != "test"`)
+	}
+	if len(named.RequestID) != 36 {
+		t.Error(`len(named.RequestID) != 36`)
+	}
+	if named.StartAt.IsZero() {
+		t.Error(`named.StartAt.IsZero()`)
+	}
+
+	env.Stop()
+	close(waitCh)
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.Tasks()) != 0 {
+		t.Error(`len(env.Tasks()) != 0`)
+	}
+}
+
+func TestEnvironmentChild(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	child := env.NewChild("child", false)
+
+	testError := errors.New("test")
+	child.Go(func(ctx context.Context) error {
+		return testError
+	})
+
+	err := child.Wait()
+	if err != testError {
+		t.Error(`err != testError`, err)
+	}
+
+	// an error in the child does not cancel the parent.
+	if env.ctx.Err() != nil {
+		t.Error(`parent should not be canceled`, env.ctx.Err())
+	}
+
+	// canceling the parent cancels the children.
+	var parentDone bool
+	env.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		parentDone = true
+		return nil
+	})
+	child2 := env.NewChild("child2", false)
+	var childDone bool
+	child2.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		childDone = true
+		return nil
+	})
+
+	env.Cancel(nil)
+	err = env.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	if !parentDone {
+		t.Error(`!parentDone`)
+	}
+	if !childDone {
+		t.Error(`!childDone`)
+	}
+
+	child3 := env.NewChild("child3", false)
+	err = child3.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnvironmentChildPropagate(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	child := env.NewChild("child", true)
+
+	testError := errors.New("test")
+	child.Go(func(ctx context.Context) error {
+		return testError
+	})
+
+	err := env.Wait()
+	if err != testError {
+		t.Error(`err != testError`, err)
+	}
+	err = child.Wait()
+	if err != testError {
+		t.Error(`err != testError`, err)
+	}
+}
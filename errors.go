@@ -0,0 +1,76 @@
+package well
+
+import (
+	"errors"
+	"strings"
+)
+
+// TaskError is an error returned from a goroutine started by
+// Environment.  It is tagged with the name and the request tracking
+// ID of the goroutine.
+//
+// Wait returns TaskErrors only if error aggregation is enabled by
+// Environment.EnableErrorAggregation.
+type TaskError struct {
+	// Name is the name of the goroutine, if any.
+	Name string
+
+	// RequestID is the request tracking ID of the goroutine, if any.
+	RequestID string
+
+	// Err is the error returned from the goroutine.
+	Err error
+}
+
+func (e *TaskError) Error() string {
+	switch {
+	case len(e.Name) > 0:
+		return e.Name + ": " + e.Err.Error()
+	case len(e.RequestID) > 0:
+		return "request_id " + e.RequestID + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns Err.
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// joinError is an error that wraps multiple errors.
+//
+// In addition to Unwrap() []error, joinError implements Is and As
+// so that errors.Is and errors.As work with older Go versions.
+type joinError struct {
+	errs []error
+}
+
+func (e *joinError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *joinError) Unwrap() []error {
+	return e.errs
+}
+
+func (e *joinError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *joinError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEnvironmentErrorAggregation(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	env.EnableErrorAggregation()
+	env.EnablePanicRecovery()
+
+	error1 := errors.New("error 1")
+	error2 := errors.New("error 2")
+
+	env.GoWithName("first", nil, func(ctx context.Context) error {
+		return error1
+	})
+	env.GoWithID(func(ctx context.Context) error {
+		<-ctx.Done()
+		return error2
+	})
+	env.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		panic("test")
+	})
+
+	err := env.Wait()
+	if !errors.Is(err, error1) {
+		t.Error(`!errors.Is(err, error1)`)
+	}
+	if !errors.Is(err, error2) {
+		t.Error(`!errors.Is(err, error2)`)
+	}
+	if !IsPanic(err) {
+		t.Error(`!IsPanic(err)`)
+	}
+	if IsSignaled(err) {
+		t.Error(`IsSignaled(err)`)
+	}
+
+	var terr *TaskError
+	if !errors.As(err, &terr) {
+		t.Fatal(`!errors.As(err, &terr)`)
+	}
+	if terr.Name != "first" {
+		t.Error(`terr.Name != "first"`, terr.Name)
+	}
+	t.Log(err)
+}
+
+func TestEnvironmentErrorAggregationSignaled(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	env.EnableErrorAggregation()
+
+	testError := errors.New("test")
+	env.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return testError
+	})
+
+	env.Cancel(errSignaled)
+	err := env.Wait()
+	if !IsSignaled(err) {
+		t.Error(`!IsSignaled(err)`)
+	}
+	if !errors.Is(err, testError) {
+		t.Error(`!errors.Is(err, testError)`)
+	}
+}
+
+func TestEnvironmentErrorAggregationNil(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	env.EnableErrorAggregation()
+	env.Go(func(ctx context.Context) error {
+		return nil
+	})
+
+	env.Stop()
+	err := env.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,495 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/cybozu-go/log"
+)
+
+const (
+	storeEnv       = "CYBOZU_STORE_FD"
+	storedFdsEnv   = "CYBOZU_STORED_FDS"
+	storedNamesEnv = "CYBOZU_STORED_FDNAMES"
+
+	// storePrefix is prepended to FDNAME of files stored in systemd
+	// to distinguish them from sockets of socket activation.
+	// The default FDNAME of a socket is its unit name, which cannot
+	// contain "/".
+	storePrefix = "well/"
+
+	maxStoreNameLength = 255 - len(storePrefix)
+)
+
+var (
+	storeOnce  sync.Once
+	storeMu    sync.Mutex
+	storeReqMu sync.Mutex
+
+	// storedFiles are files restored at startup or stored by StoreFile.
+	storedFiles = make(map[string]*os.File)
+
+	// storeConn is connected to the master process of Graceful
+	// in child processes.
+	storeConn *net.UnixConn
+)
+
+// StoreFile stores a duplicate of f with name so that it can be
+// retrieved by StoredFile after the program restarts.
+//
+// In child processes of Graceful, the file is sent to the master
+// process and passed to new child processes.  Otherwise, the file
+// is stored in systemd's file descriptor store if NOTIFY_SOCKET is
+// set.  The master process of Graceful also stores files in systemd.
+// Note that FileDescriptorStoreMax= needs to be configured for systemd.
+//
+// If a file with the same name has been stored, it is replaced.
+// name must consist of printable ASCII characters except ":".
+//
+// This returns an error if there is no place to keep the file,
+// that is, the program is neither a child or master process of
+// Graceful nor run with NOTIFY_SOCKET.
+func StoreFile(name string, f *os.File) error {
+	if err := validateStoreName(name); err != nil {
+		return err
+	}
+	restoreFileStore()
+
+	storeMu.Lock()
+	conn := storeConn
+	storeMu.Unlock()
+
+	if conn == nil && !canStoreLocally() {
+		return errors.New("no file store is available")
+	}
+
+	fd, err := dupCloseOnExec(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	dup := os.NewFile(uintptr(fd), name)
+
+	if conn != nil {
+		err = requestStore(conn, "store "+name, dup)
+	} else {
+		err = systemdStore(name, dup)
+	}
+	if err != nil {
+		dup.Close()
+		return err
+	}
+
+	putStoredFile(name, dup)
+	return nil
+}
+
+// StoredFile returns the file stored with name by StoreFile,
+// or nil if not found.  The returned file is owned by the store;
+// do not close it.
+func StoredFile(name string) *os.File {
+	restoreFileStore()
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	return storedFiles[name]
+}
+
+// RemoveStoredFile removes the file stored with name.
+func RemoveStoredFile(name string) error {
+	restoreFileStore()
+
+	storeMu.Lock()
+	conn := storeConn
+	storeMu.Unlock()
+
+	var err error
+	if conn != nil {
+		err = requestStore(conn, "remove "+name, nil)
+	} else {
+		err = systemdRemove(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	putStoredFile(name, nil)
+	return nil
+}
+
+// canStoreLocally returns true if files stored in this process survive
+// restarts without the master process of Graceful.
+func canStoreLocally() bool {
+	if !isMaster() {
+		// the store socket of this child is not available.
+		return false
+	}
+	return len(os.Getenv("NOTIFY_SOCKET")) > 0 || atomic.LoadInt32(&gracefulMasters) > 0
+}
+
+// isStoredFDName returns true if name is FDNAME of a file stored by StoreFile.
+func isStoredFDName(name string) bool {
+	return strings.HasPrefix(name, storePrefix)
+}
+
+func validateStoreName(name string) error {
+	if len(name) == 0 || len(name) > maxStoreNameLength {
+		return errors.New("invalid length of file name: " + name)
+	}
+	for _, c := range []byte(name) {
+		if c < ' ' || c > '~' || c == ':' {
+			return errors.New("invalid file name: " + name)
+		}
+	}
+	return nil
+}
+
+// putStoredFile replaces the file stored with name by f.
+// If f is nil, the file is removed.
+func putStoredFile(name string, f *os.File) {
+	storeMu.Lock()
+	old := storedFiles[name]
+	if f == nil {
+		delete(storedFiles, name)
+	} else {
+		storedFiles[name] = f
+	}
+	storeMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// storedFileSet is a set of duplicates of stored files to pass to
+// a child process.  The duplicates stay open even if the stored files
+// are replaced by serveStore concurrently, until close is called
+// after the child is started.
+type storedFileSet struct {
+	names []string
+	files []*os.File
+}
+
+func (s *storedFileSet) close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+}
+
+// snapshotStoredFiles returns duplicates of the stored files sorted
+// by their names.
+func snapshotStoredFiles() (*storedFileSet, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	s := &storedFileSet{
+		names: make([]string, 0, len(storedFiles)),
+	}
+	for name := range storedFiles {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	for _, name := range s.names {
+		fd, err := dupCloseOnExec(int(storedFiles[name].Fd()))
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.files = append(s.files, os.NewFile(uintptr(fd), name))
+	}
+	return s, nil
+}
+
+// dupCloseOnExec duplicates fd with FD_CLOEXEC set.
+func dupCloseOnExec(fd int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+
+	nfd, err := syscall.Dup(fd)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(nfd)
+	return nfd, nil
+}
+
+// restoreFileStore restores stored files from the master process of
+// Graceful, or from systemd.  This is done only once.
+func restoreFileStore() {
+	storeOnce.Do(func() {
+		err := doRestoreFileStore()
+		if err != nil {
+			log.Error("well: failed to restore stored files", map[string]interface{}{
+				log.FnError: err.Error(),
+			})
+		}
+	})
+}
+
+func doRestoreFileStore() error {
+	if v := os.Getenv(storeEnv); len(v) > 0 {
+		os.Unsetenv(storeEnv)
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		closeOnExec(fd)
+		f := os.NewFile(uintptr(fd), "store")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		storeMu.Lock()
+		storeConn = c.(*net.UnixConn)
+		storeMu.Unlock()
+	}
+
+	if v := os.Getenv(storedFdsEnv); len(v) > 0 {
+		os.Unsetenv(storedFdsEnv)
+		names := restoreNames(storedNamesEnv)
+		offset, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		storeMu.Lock()
+		for i, name := range names {
+			fd := offset + i
+			closeOnExec(fd)
+			storedFiles[name] = os.NewFile(uintptr(fd), name)
+		}
+		storeMu.Unlock()
+		return nil
+	}
+
+	if !isMaster() || len(os.Getenv("LISTEN_PID")) == 0 {
+		return nil
+	}
+	sockets, _, err := restoreSystemdSockets()
+	if err != nil {
+		return err
+	}
+	storeMu.Lock()
+	for _, s := range sockets {
+		if s.file != nil {
+			storedFiles[strings.TrimPrefix(s.name, storePrefix)] = s.file
+		}
+	}
+	storeMu.Unlock()
+	return nil
+}
+
+// requestStore sends a request to the master process of Graceful,
+// and waits for the response.
+func requestStore(conn *net.UnixConn, req string, f *os.File) error {
+	storeReqMu.Lock()
+	defer storeReqMu.Unlock()
+
+	var oob []byte
+	if f != nil {
+		oob = syscall.UnixRights(int(f.Fd()))
+	}
+	_, _, err := conn.WriteMsgUnix([]byte(req), oob, nil)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if resp := string(buf[:n]); resp != "ok" {
+		return errors.New(resp)
+	}
+	return nil
+}
+
+// serveStore handles requests from a child process over conn
+// until conn is closed.
+func serveStore(conn *net.UnixConn) {
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 {
+			return
+		}
+
+		var f *os.File
+		if oobn > 0 {
+			f, err = parseRights(oob[:oobn])
+		}
+		if err == nil {
+			err = handleStoreRequest(string(buf[:n]), f)
+		}
+
+		resp := "ok"
+		if err != nil {
+			resp = err.Error()
+			if f != nil {
+				f.Close()
+			}
+		}
+		_, err = conn.Write([]byte(resp))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// closeStoreConn closes the master's socket for the file store.
+// This is needed to stop serveStore because datagram sockets
+// are not notified when the child closes its socket.
+func closeStoreConn(conn *net.UnixConn) {
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func parseRights(oob []byte) (*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, errors.New("invalid control message")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, errors.New("invalid number of fds")
+	}
+	closeOnExec(fds[0])
+	return os.NewFile(uintptr(fds[0]), "stored"), nil
+}
+
+// closeOnExec sets FD_CLOEXEC on fd while holding syscall.ForkLock
+// so that fd does not leak to processes started concurrently.
+func closeOnExec(fd int) {
+	syscall.ForkLock.RLock()
+	syscall.CloseOnExec(fd)
+	syscall.ForkLock.RUnlock()
+}
+
+// handleStoreRequest handles a request from a child in the master process.
+func handleStoreRequest(req string, f *os.File) error {
+	op, name, _ := strings.Cut(req, " ")
+	if err := validateStoreName(name); err != nil {
+		return err
+	}
+
+	switch op {
+	case "store":
+		if f == nil {
+			return errors.New("no file to store")
+		}
+		err := systemdStore(name, f)
+		if err != nil {
+			log.Warn("well: failed to store file in systemd", map[string]interface{}{
+				"name":      name,
+				log.FnError: err.Error(),
+			})
+		}
+		putStoredFile(name, f)
+	case "remove":
+		err := systemdRemove(name)
+		if err != nil {
+			log.Warn("well: failed to remove file from systemd", map[string]interface{}{
+				"name":      name,
+				log.FnError: err.Error(),
+			})
+		}
+		putStoredFile(name, nil)
+	default:
+		return errors.New("invalid request: " + op)
+	}
+	return nil
+}
+
+// systemdStore stores f in systemd's file descriptor store.
+// This does nothing if NOTIFY_SOCKET is not set.
+func systemdStore(name string, f *os.File) error {
+	err := systemdRemove(name)
+	if err != nil {
+		return err
+	}
+	return systemdNotifyFile("FDSTORE=1\nFDNAME="+storePrefix+name, f)
+}
+
+// systemdRemove removes the file stored with name from systemd.
+func systemdRemove(name string) error {
+	return SystemdNotify("FDSTOREREMOVE=1", "FDNAME="+storePrefix+name)
+}
+
+// systemdNotifyFile sends state with f to the service manager.
+func systemdNotifyFile(state string, f *os.File) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if len(addr) == 0 {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	// net.UnixConn cannot send control messages over a connected
+	// datagram socket.
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	return syscall.Sendmsg(fd, []byte(state), syscall.UnixRights(int(f.Fd())), &syscall.SockaddrUnix{Name: addr}, 0)
+}
+
+// newStorePair creates a connected pair of sockets for a child process
+// to store files.  The first is for the master, and the second is for
+// the child.
+//
+// SOCK_DGRAM is used because SOCK_SEQPACKET is not available on some
+// platforms such as macOS.  As datagram sockets do not notice that
+// the peer is closed, the master needs to close its socket when the
+// child exits.
+func newStorePair() (*net.UnixConn, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "store")
+	c, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return c.(*net.UnixConn), os.NewFile(uintptr(fds[1]), "store"), nil
+}
@@ -0,0 +1,189 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateStoreName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"a", "state.db", strings.Repeat("x", maxStoreNameLength)} {
+		if err := validateStoreName(name); err != nil {
+			t.Error(name, err)
+		}
+	}
+	for _, name := range []string{"", "a:b", "a\nb", "日本", strings.Repeat("x", maxStoreNameLength+1)} {
+		if err := validateStoreName(name); err == nil {
+			t.Error("should be invalid:", name)
+		}
+	}
+}
+
+func TestStoreRequest(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	mc, cf, err := newStorePair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		serveStore(mc)
+		close(done)
+	}()
+
+	c, err := net.FileConn(cf)
+	cf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := c.(*net.UnixConn)
+	defer conn.Close()
+
+	f, err := os.CreateTemp(t.TempDir(), "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteString("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = requestStore(conn, "store test-request", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putStoredFile("test-request", nil)
+
+	stored := StoredFile("test-request")
+	if stored == nil {
+		t.Fatal("file is not stored")
+	}
+	if stored.Fd() == f.Fd() {
+		t.Error("stored file should have a different fd")
+	}
+	data, err := io.ReadAll(io.NewSectionReader(stored, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Error("unexpected content:", string(data))
+	}
+
+	err = requestStore(conn, "store test-request", nil)
+	if err == nil {
+		t.Error("store without a file should fail")
+	}
+	err = requestStore(conn, "store bad:name", f)
+	if err == nil {
+		t.Error("invalid name should fail")
+	}
+
+	err = requestStore(conn, "remove test-request", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if StoredFile("test-request") != nil {
+		t.Error("file is not removed")
+	}
+
+	closeStoreConn(mc)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("serveStore should return when the socket is closed")
+	}
+}
+
+func TestSnapshotStoredFiles(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putStoredFile("test-snapshot", f)
+	defer putStoredFile("test-snapshot", nil)
+
+	s, err := snapshotStoredFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	var dup *os.File
+	for i, name := range s.names {
+		if name == "test-snapshot" {
+			dup = s.files[i]
+		}
+	}
+	if dup == nil {
+		t.Fatal("file is not in the snapshot")
+	}
+	if dup.Fd() == f.Fd() {
+		t.Error("snapshot should have a duplicate")
+	}
+
+	// replacing the stored file closes f, but not the duplicate.
+	putStoredFile("test-snapshot", nil)
+	data, err := io.ReadAll(io.NewSectionReader(dup, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Error("unexpected content:", string(data))
+	}
+}
+
+func TestIsStoredFDName(t *testing.T) {
+	t.Parallel()
+
+	if !isStoredFDName(storePrefix + "cache") {
+		t.Error("stored file should be detected")
+	}
+	// default FDNAMEs of socket units.
+	for _, name := range []string{"well.socket", "well", "well@1.socket", "foo.socket"} {
+		if isStoredFDName(name) {
+			t.Error("socket should not be detected as a stored file:", name)
+		}
+	}
+}
+
+func TestStoreFileUnavailable(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	f, err := os.CreateTemp(t.TempDir(), "unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = StoreFile("test-unavailable", f)
+	if err == nil {
+		t.Error("StoreFile should fail without a file store")
+	}
+	if StoredFile("test-unavailable") != nil {
+		t.Error("file should not be stored")
+	}
+
+	// the master process of Graceful keeps the file for children.
+	atomic.AddInt32(&gracefulMasters, 1)
+	defer atomic.AddInt32(&gracefulMasters, -1)
+	err = StoreFile("test-unavailable", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putStoredFile("test-unavailable", nil)
+}
@@ -0,0 +1,21 @@
+package well
+
+import (
+	"errors"
+	"os"
+)
+
+// StoreFile is not supported on Windows.
+func StoreFile(name string, f *os.File) error {
+	return errors.New("not supported on Windows")
+}
+
+// StoredFile always returns nil on Windows.
+func StoredFile(name string) *os.File {
+	return nil
+}
+
+// RemoveStoredFile is not supported on Windows.
+func RemoveStoredFile(name string) error {
+	return errors.New("not supported on Windows")
+}
@@ -1,28 +1,227 @@
 package well
 
 import (
+	"errors"
 	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Graceful is a struct to implement graceful restart servers.
 //
+// The master process itself can be replaced with a new executable
+// by a signal mapped to ActionUpgrade.  The master re-executes
+// os.Args[0] and passes the listeners to the new master.  Once all
+// children of the new master become ready, the old master stops its
+// children and cancels its environment.  If the new master does not
+// become ready, it is stopped and the old master keeps running.
+//
 // On Windows, this is just a dummy to make porting easy.
 type Graceful struct {
 	// Listen is a function to create listening sockets.
 	// This function is called in the master process.
+	// It is not called in a new master started by ActionUpgrade.
 	Listen func() ([]net.Listener, error)
 
+	// ListenNamed is the same as Listen but returns named listeners.
+	// If not nil, this is used instead of Listen.
+	//
+	// Names must not be empty nor contain ":".
+	ListenNamed func() (map[string]net.Listener, error)
+
+	// ListenPacket is a function to create packet connections such as
+	// UDP or unixgram sockets.  This function is called in the master
+	// process in addition to Listen or ListenNamed, and may be nil.
+	// It is not called in a new master started by ActionUpgrade.
+	//
+	// The connections are passed to ServePacket or ServeNamedPacket.
+	ListenPacket func() ([]net.PacketConn, error)
+
 	// Serve is a function to accept connections from listeners.
 	// This function is called in child processes.
 	// In case of errors, use os.Exit to exit.
 	Serve func(listeners []net.Listener)
 
+	// ServeNamed is the same as Serve but takes named listeners
+	// returned from ListenNamed.  If not nil, this is used instead
+	// of Serve.
+	//
+	// If ListenNamed is used with Serve, Serve takes listeners
+	// sorted by their names.
+	ServeNamed func(listeners map[string]net.Listener)
+
+	// ServePacket is the same as Serve but takes packet connections
+	// returned from ListenPacket in addition to listeners.
+	// If not nil, this is used instead of Serve and ServeNamed.
+	ServePacket func(listeners []net.Listener, conns []net.PacketConn)
+
+	// ServeNamedPacket is the same as ServeNamed but takes packet
+	// connections returned from ListenPacket in addition to named
+	// listeners returned from ListenNamed.  If not nil, this is used
+	// instead of Serve, ServeNamed, and ServePacket.
+	ServeNamedPacket func(listeners map[string]net.Listener, conns []net.PacketConn)
+
+	// Workers is the number of child processes.  All children share
+	// the listeners and are restarted one by one on SIGHUP.
+	// Each child can know its index by WorkerIndex.
+	// Zero is treated as 1.
+	Workers int
+
+	// ManualReady makes the child process responsible for calling
+	// NotifyReady when it becomes ready to serve.
+	// If false, the child is considered ready when Serve is called.
+	ManualReady bool
+
+	// ReadyTimeout is duration before the master gives up waiting for
+	// a new child to become ready on restart.  If the new child does
+	// not become ready, it is killed and the old child keeps running.
+	// Zero means 30 seconds.
+	ReadyTimeout time.Duration
+
+	// CrashPolicy, if not nil, makes the master process restart
+	// the child process when it exits unexpectedly.  Restarts are
+	// delayed and limited according to the policy.  Each crash is
+	// logged with the exit code or the signal that killed the child.
+	//
+	// If nil, the master process stops when the child exits.
+	// This does not affect restarts by SIGHUP.
+	CrashPolicy *RestartPolicy
+
 	// ExitTimeout is duration before Run gives up waiting for
 	// a child to exit.  Zero disables timeout.
 	ExitTimeout time.Duration
 
+	// ChildFiles, if not nil, is called in the master process every
+	// time a child process is started.  The returned files are passed
+	// to the child, and can be retrieved by InheritedFile there.
+	// The files are not closed by Graceful.
+	//
+	// Names must not be empty nor contain ":".
+	ChildFiles func() (map[string]*os.File, error)
+
+	// ChildState, if not nil, is called in the master process every
+	// time a child process is started.  The returned data is passed
+	// to the child, and can be retrieved by InheritedState there.
+	// The data must not exceed 64 KiB.
+	//
+	// If ChildFiles or ChildState returns an error, the child is not
+	// started in the same way as it fails to start.
+	ChildState func() ([]byte, error)
+
+	// WrapChildOutput makes the master process log lines written to
+	// stdout or stderr of child processes as structured logs if they
+	// are not formatted by the logger, e.g. outputs of fmt.Println or
+	// panics.  The logs have "child_pid", "generation", "worker", and
+	// "stream" fields.
+	//
+	// If false, lines are written to the log as they are.
+	WrapChildOutput bool
+
+	// ChildOutputBuffer is the number of lines of stdout and stderr
+	// buffered for each child process.  If the buffer is full because
+	// logs cannot be written fast enough, further lines are dropped
+	// and the number of dropped lines is logged.
+	// Zero means 1024.
+	ChildOutputBuffer int
+
+	// ControlSocket, if not empty, is the path of a unix socket for
+	// the master process to accept commands.  A command is a line of
+	// "status", "restart", "stop", or "reopen-logs", and the response
+	// is a JSON object of ControlResponse.  Use ControlClient to send
+	// commands.
+	//
+	// The socket is created with permission 0600, and is passed to
+	// a new master started by ActionUpgrade.  A stale socket left by
+	// a crashed master is removed, but the master fails to start if
+	// another process is listening on the socket.  This is ignored on Windows.
+	ControlSocket string
+
 	// Env is the environment for the master process.
 	// If nil, the global environment is used.
 	Env *Environment
+
+	mu     sync.Mutex
+	master *master
+}
+
+// listen calls ListenNamed or Listen, and ListenPacket.
+// names is nil if Listen is called.
+func (g *Graceful) listen() (names []string, listeners []net.Listener, conns []net.PacketConn, err error) {
+	if g.ListenPacket != nil {
+		conns, err = g.ListenPacket()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	switch {
+	case g.ListenNamed != nil:
+		m, err := g.ListenNamed()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for name := range m {
+			if len(name) == 0 || strings.Contains(name, ":") {
+				return nil, nil, nil, errors.New("invalid listener name: " + name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			listeners = append(listeners, m[name])
+		}
+	case g.Listen != nil:
+		listeners, err = g.Listen()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return names, listeners, conns, nil
+}
+
+// namedListeners makes a map from names and listeners.
+func namedListeners(names []string, listeners []net.Listener) (map[string]net.Listener, error) {
+	if len(names) != len(listeners) {
+		return nil, errors.New("the number of names does not match the number of listeners")
+	}
+	m := make(map[string]net.Listener, len(names))
+	for i, name := range names {
+		if _, ok := m[name]; ok {
+			return nil, errors.New("duplicate listener name: " + name)
+		}
+		m[name] = listeners[i]
+	}
+	return m, nil
+}
+
+// serveFunc returns a function to call ServeNamedPacket, ServePacket,
+// ServeNamed, or Serve in this order of precedence.
+func (g *Graceful) serveFunc(names []string, listeners []net.Listener, conns []net.PacketConn) (func(), error) {
+	switch {
+	case g.ServeNamedPacket != nil:
+		named, err := g.namedListeners(names, listeners)
+		if err != nil {
+			return nil, err
+		}
+		return func() { g.ServeNamedPacket(named, conns) }, nil
+	case g.ServePacket != nil:
+		return func() { g.ServePacket(listeners, conns) }, nil
+	case g.ServeNamed != nil:
+		named, err := g.namedListeners(names, listeners)
+		if err != nil {
+			return nil, err
+		}
+		return func() { g.ServeNamed(named) }, nil
+	}
+	return func() { g.Serve(listeners) }, nil
+}
+
+func (g *Graceful) namedListeners(names []string, listeners []net.Listener) (map[string]net.Listener, error) {
+	if names == nil {
+		return nil, errors.New("ServeNamed and ServeNamedPacket require ListenNamed")
+	}
+	return namedListeners(names, listeners)
 }
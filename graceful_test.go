@@ -3,10 +3,133 @@
 
 package well
 
-import "testing"
+import (
+	"net"
+	"reflect"
+	"testing"
+)
 
 func TestGraceful(t *testing.T) {
 	t.Skip(`Graceful cannot be tested by go test as it executes
 itself in another process of go test.
 Instead, we test Graceful in a test program under "test/graceful".`)
 }
+
+func TestGracefulListenNamed(t *testing.T) {
+	t.Parallel()
+
+	ln1, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln1.Close()
+	ln2, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln2.Close()
+
+	g := &Graceful{
+		ListenNamed: func() (map[string]net.Listener, error) {
+			return map[string]net.Listener{"http": ln1, "admin": ln2}, nil
+		},
+	}
+	names, lns, _, err := g.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"admin", "http"}) {
+		t.Error("unexpected names:", names)
+	}
+	if len(lns) != 2 || lns[0] != ln2 || lns[1] != ln1 {
+		t.Error("unexpected listeners:", lns)
+	}
+
+	m, err := namedListeners(names, lns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["http"] != ln1 || m["admin"] != ln2 {
+		t.Error("unexpected map:", m)
+	}
+
+	_, err = namedListeners([]string{"http", "http"}, lns)
+	if err == nil {
+		t.Error("duplicate names should cause an error")
+	}
+	_, err = namedListeners([]string{"http"}, lns)
+	if err == nil {
+		t.Error("mismatched names should cause an error")
+	}
+
+	g.ListenNamed = func() (map[string]net.Listener, error) {
+		return map[string]net.Listener{"a:b": ln1}, nil
+	}
+	_, _, _, err = g.listen()
+	if err == nil {
+		t.Error("invalid name should cause an error")
+	}
+}
+
+func TestGracefulServeFunc(t *testing.T) {
+	t.Parallel()
+
+	ln1, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln1.Close()
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+
+	lns := []net.Listener{ln1}
+	conns := []net.PacketConn{pc}
+
+	var called string
+	g := &Graceful{
+		Serve: func(listeners []net.Listener) {
+			called = "Serve"
+		},
+		ServeNamed: func(listeners map[string]net.Listener) {
+			called = "ServeNamed"
+		},
+		ServePacket: func(listeners []net.Listener, conns []net.PacketConn) {
+			called = "ServePacket"
+		},
+		ServeNamedPacket: func(listeners map[string]net.Listener, conns []net.PacketConn) {
+			if listeners["dns-tcp"] == ln1 && len(conns) == 1 && conns[0] == pc {
+				called = "ServeNamedPacket"
+			}
+		},
+	}
+	expected := []string{"ServeNamedPacket", "ServePacket", "ServeNamed", "Serve"}
+	for _, e := range expected {
+		serve, err := g.serveFunc([]string{"dns-tcp"}, lns, conns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		called = ""
+		serve()
+		if called != e {
+			t.Error("unexpected function is called:", called, e)
+		}
+
+		switch e {
+		case "ServeNamedPacket":
+			g.ServeNamedPacket = nil
+		case "ServePacket":
+			g.ServePacket = nil
+		case "ServeNamed":
+			g.ServeNamed = nil
+		}
+	}
+
+	g.ServeNamedPacket = func(listeners map[string]net.Listener, conns []net.PacketConn) {}
+	_, err = g.serveFunc(nil, lns, conns)
+	if err == nil {
+		t.Error("ServeNamedPacket without ListenNamed should cause an error")
+	}
+}
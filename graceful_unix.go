@@ -6,12 +6,14 @@ package well
 import (
 	"context"
 	"errors"
-	"io"
+	"fmt"
 	"net"
 	"os"
 	"os/exec"
-	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,13 +21,29 @@ import (
 )
 
 const (
-	listenEnv = "CYBOZU_LISTEN_FDS"
+	listenEnv      = "CYBOZU_LISTEN_FDS"
+	listenNamesEnv = "CYBOZU_LISTEN_FDNAMES"
+	packetEnv      = "CYBOZU_PACKET_FDS"
+	readyEnv       = "CYBOZU_READY_FD"
+	workerEnv      = "CYBOZU_WORKER_INDEX"
 
-	restartWait = 10 * time.Millisecond
+	// upgradeEnv is set for a new master process started by upgrade.
+	upgradeEnv = "CYBOZU_UPGRADE_FDS"
+
+	defaultReadyTimeout = 30 * time.Second
+)
+
+var (
+	readyMu   sync.Mutex
+	readyPipe *os.File
 )
 
+// masterProcess is false in child processes of Graceful.  This is determined
+// at startup because the child unsets listenEnv on restoring listeners.
+var masterProcess = len(os.Getenv(listenEnv)) == 0
+
 func isMaster() bool {
-	return len(os.Getenv(listenEnv)) == 0
+	return masterProcess
 }
 
 type fileFunc interface {
@@ -48,6 +66,22 @@ func listenerFiles(listeners []net.Listener) ([]*os.File, error) {
 	return files, nil
 }
 
+func packetConnFiles(conns []net.PacketConn) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(conns))
+	for _, c := range conns {
+		fd, ok := c.(fileFunc)
+		if !ok {
+			return nil, errors.New("no File() method for " + c.LocalAddr().String())
+		}
+		f, err := fd.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
 func restoreListeners(envvar string) ([]net.Listener, error) {
 	nfds, err := strconv.Atoi(os.Getenv(envvar))
 	defer os.Unsetenv(envvar)
@@ -77,16 +111,256 @@ func restoreListeners(envvar string) ([]net.Listener, error) {
 	return ls, nil
 }
 
+// restoreNames returns the names of listeners from envvar.
+// The value of envvar is a colon-separated list of names.
+func restoreNames(envvar string) []string {
+	v, ok := os.LookupEnv(envvar)
+	os.Unsetenv(envvar)
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, ":")
+}
+
+// restorePacketConns restores packet connections from file descriptors
+// starting at offset.  The number of them is read from envvar.
+func restorePacketConns(envvar string, offset int) ([]net.PacketConn, error) {
+	v := os.Getenv(envvar)
+	os.Unsetenv(envvar)
+	if len(v) == 0 {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, err
+	}
+
+	conns := make([]net.PacketConn, 0, nfds)
+	for i := 0; i < nfds; i++ {
+		fd := offset + i
+		f := os.NewFile(uintptr(fd), "FD"+strconv.Itoa(fd))
+		c, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
+
+// restoreReadyPipe takes the pipe to notify readiness to the master.
+func restoreReadyPipe() error {
+	v := os.Getenv(readyEnv)
+	os.Unsetenv(readyEnv)
+	if len(v) == 0 {
+		// the master may be an older version.
+		return nil
+	}
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fd)
+
+	readyMu.Lock()
+	readyPipe = os.NewFile(uintptr(fd), "ready")
+	readyMu.Unlock()
+	return nil
+}
+
+// NotifyReady notifies the master process of Graceful that this
+// child process is ready to serve.  The master process terminates
+// the old child process only after the new one becomes ready.
+//
+// Call this only when Graceful.ManualReady is true.  Otherwise,
+// Graceful.Run calls this before calling Graceful.Serve.
+//
+// If the process is not a child of Graceful, this sends READY=1 to
+// systemd instead.  See SystemdNotify and EnableAutoReady.
+//
+// This does nothing if the child has already notified readiness.
+func NotifyReady() error {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+
+	if readyPipe == nil {
+		if isMaster() {
+			return SystemdNotify("READY=1")
+		}
+		return nil
+	}
+	_, err := readyPipe.Write([]byte{'1'})
+	readyPipe.Close()
+	readyPipe = nil
+	return err
+}
+
+// WorkerIndex returns the worker index of this child process of
+// Graceful, which is in the range of [0, Graceful.Workers).
+//
+// The index is also available as CYBOZU_WORKER_INDEX environment variable.
+// This returns 0 if the process is not a child of Graceful.
+func WorkerIndex() int {
+	idx, _ := strconv.Atoi(os.Getenv(workerEnv))
+	return idx
+}
+
+// systemdSocket is a socket passed by systemd socket activation.
+// One of ln, conn, or file is not nil.  file is a file stored by
+// StoreFile.
+type systemdSocket struct {
+	name string
+	ln   net.Listener
+	conn net.PacketConn
+	file *os.File
+}
+
+var (
+	systemdOnce    sync.Once
+	systemdSockets []systemdSocket
+	systemdNamed   bool
+	systemdErr     error
+)
+
+// restoreSystemdSockets restores sockets from systemd socket activation.
+// Sockets are restored only once and cached.
+func restoreSystemdSockets() ([]systemdSocket, bool, error) {
+	systemdOnce.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil {
+			systemdErr = err
+			return
+		}
+		if pid != os.Getpid() {
+			return
+		}
+
+		names := restoreNames("LISTEN_FDNAMES")
+		nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		os.Unsetenv("LISTEN_FDS")
+		if err != nil {
+			systemdErr = err
+			return
+		}
+		if names != nil && len(names) != nfds {
+			systemdErr = errors.New("LISTEN_FDNAMES does not match LISTEN_FDS")
+			return
+		}
+
+		log.Debug("well: restored systemd sockets", map[string]interface{}{
+			"nfds": nfds,
+		})
+
+		sockets := make([]systemdSocket, 0, nfds)
+		for i := 0; i < nfds; i++ {
+			fd := 3 + i
+			if names != nil && isStoredFDName(names[i]) {
+				syscall.CloseOnExec(fd)
+				sockets = append(sockets, systemdSocket{
+					name: names[i],
+					file: os.NewFile(uintptr(fd), names[i]),
+				})
+				continue
+			}
+
+			ln, conn, err := fileSocket(fd)
+			if err != nil {
+				systemdErr = err
+				return
+			}
+			s := systemdSocket{ln: ln, conn: conn}
+			if names != nil {
+				s.name = names[i]
+			}
+			sockets = append(sockets, s)
+		}
+		systemdSockets = sockets
+		systemdNamed = names != nil
+	})
+	return systemdSockets, systemdNamed, systemdErr
+}
+
+// fileSocket converts fd into a listener or a packet connection
+// according to its socket type.
+func fileSocket(fd int) (net.Listener, net.PacketConn, error) {
+	typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "FD"+strconv.Itoa(fd))
+	defer f.Close()
+
+	if typ == syscall.SOCK_DGRAM {
+		conn, err := net.FilePacketConn(f)
+		return nil, conn, err
+	}
+	ln, err := net.FileListener(f)
+	return ln, nil, err
+}
+
 // SystemdListeners returns listeners from systemd socket activation.
+//
+// Datagram sockets are not included.  Use SystemdPacketConns for them.
+// Files stored by StoreFile are not included either.
 func SystemdListeners() ([]net.Listener, error) {
-	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	sockets, _, err := restoreSystemdSockets()
+	if err != nil {
+		return nil, err
+	}
+	var lns []net.Listener
+	for _, s := range sockets {
+		if s.ln != nil {
+			lns = append(lns, s.ln)
+		}
+	}
+	return lns, nil
+}
+
+// SystemdNamedListeners returns listeners from systemd socket activation
+// as a map keyed by the names in LISTEN_FDNAMES.
+//
+// The names can be specified by FileDescriptorName= in socket units.
+// This returns an error if LISTEN_FDNAMES is not set, or if the names
+// are not unique.
+func SystemdNamedListeners() (map[string]net.Listener, error) {
+	sockets, named, err := restoreSystemdSockets()
 	if err != nil {
 		return nil, err
 	}
-	if pid != os.Getpid() {
+	if len(sockets) == 0 {
 		return nil, nil
 	}
-	return restoreListeners("LISTEN_FDS")
+	if !named {
+		return nil, errors.New("LISTEN_FDNAMES is not set")
+	}
+
+	var names []string
+	var lns []net.Listener
+	for _, s := range sockets {
+		if s.ln != nil {
+			names = append(names, s.name)
+			lns = append(lns, s.ln)
+		}
+	}
+	return namedListeners(names, lns)
+}
+
+// SystemdPacketConns returns packet connections of datagram sockets
+// from systemd socket activation.
+func SystemdPacketConns() ([]net.PacketConn, error) {
+	sockets, _, err := restoreSystemdSockets()
+	if err != nil {
+		return nil, err
+	}
+	var conns []net.PacketConn
+	for _, s := range sockets {
+		if s.conn != nil {
+			conns = append(conns, s.conn)
+		}
+	}
+	return conns, nil
 }
 
 // Run runs the graceful restarting server.
@@ -108,15 +382,39 @@ func (g *Graceful) Run() {
 		return
 	}
 
+	names := restoreNames(listenNamesEnv)
 	lns, err := restoreListeners(listenEnv)
 	if err != nil {
 		log.ErrorExit(err)
 	}
+	conns, err := restorePacketConns(packetEnv, 3+len(lns))
+	if err != nil {
+		log.ErrorExit(err)
+	}
+	serve, err := g.serveFunc(names, lns, conns)
+	if err != nil {
+		log.ErrorExit(err)
+	}
+	err = restoreReadyPipe()
+	if err != nil {
+		log.ErrorExit(err)
+	}
+	restoreFileStore()
+	err = restoreInherited()
+	if err != nil {
+		log.ErrorExit(err)
+	}
 	log.DefaultLogger().SetDefaults(map[string]interface{}{
 		"pid": os.Getpid(),
 	})
 	log.Info("well: new child", nil)
-	g.Serve(lns)
+	if !g.ManualReady {
+		err = NotifyReady()
+		if err != nil {
+			log.ErrorExit(err)
+		}
+	}
+	serve()
 
 	// child process should not return.
 	os.Exit(0)
@@ -124,20 +422,44 @@ func (g *Graceful) Run() {
 
 // runMaster is the main function of the master process.
 func (g *Graceful) runMaster(ctx context.Context) error {
-	logger := log.DefaultLogger()
+	atomic.AddInt32(&gracefulMasters, 1)
+	defer atomic.AddInt32(&gracefulMasters, -1)
 
 	// prepare listener files
-	listeners, err := g.Listen()
+	upgraded := len(os.Getenv(upgradeEnv)) > 0
+	var listeners []net.Listener
+	var conns []net.PacketConn
+	var names []string
+	var err error
+	if upgraded {
+		names = restoreNames(listenNamesEnv)
+		listeners, err = restoreListeners(upgradeEnv)
+		if err == nil {
+			conns, err = restorePacketConns(packetEnv, 3+len(listeners))
+		}
+		if err == nil {
+			err = restoreReadyPipe()
+		}
+	} else {
+		names, listeners, conns, err = g.listen()
+	}
 	if err != nil {
 		return err
 	}
+	restoreFileStore()
 	files, err := listenerFiles(listeners)
 	if err != nil {
 		return err
 	}
+	packetFiles, err := packetConnFiles(conns)
+	if err != nil {
+		return err
+	}
+	files = append(files, packetFiles...)
 	if len(files) == 0 {
 		return errors.New("no listener")
 	}
+	var m *master
 	defer func() {
 		for _, f := range files {
 			f.Close()
@@ -145,105 +467,773 @@ func (g *Graceful) runMaster(ctx context.Context) error {
 		// we cannot close listeners no sooner than this point
 		// because net.UnixListener removes the socket file on Close.
 		for _, l := range listeners {
+			if ul, ok := l.(*net.UnixListener); ok && m != nil && m.upgraded {
+				// the socket file is used by the new master.
+				ul.SetUnlinkOnClose(false)
+			}
 			l.Close()
 		}
+		for _, c := range conns {
+			c.Close()
+		}
 	}()
 
-	sighup := make(chan os.Signal, 2)
-	signal.Notify(sighup, syscall.SIGHUP)
+	setDefaultSignal(syscall.SIGHUP, ActionRestart)
+	sighup, unsubscribe := subscribeSignalAction(ActionRestart)
+	defer unsubscribe()
+
+	upgrade, unsubscribeUpgrade := subscribeSignalAction(ActionUpgrade)
+	defer unsubscribeUpgrade()
+
+	m = newMaster(g, files, names, len(conns))
+	if upgraded {
+		gen, err := strconv.Atoi(os.Getenv(generationEnv))
+		if err == nil {
+			m.generation = gen + 1
+		}
+		os.Unsetenv(generationEnv)
+	}
+	g.mu.Lock()
+	g.master = m
+	g.mu.Unlock()
+	defer close(m.quit)
+
+	// the socket file of an inherited control socket is owned by
+	// the old master until this master becomes ready.
+	ownControl := len(os.Getenv(controlEnv)) == 0
+	if len(g.ControlSocket) > 0 {
+		ln, err := listenControl(g.ControlSocket)
+		if err != nil {
+			return err
+		}
+		m.controlLn = ln
+		defer func() {
+			ln.Close()
+			if ownControl && !m.upgraded {
+				os.Remove(g.ControlSocket)
+			}
+		}()
+		go m.serveControl(ln)
+	}
+
+	for _, w := range m.workers {
+		err := m.spawn(w)
+		if err != nil {
+			m.stop()
+			return err
+		}
+	}
+
+	if upgraded {
+		err := m.waitReady(ctx)
+		if err != nil {
+			m.stop()
+			return err
+		}
+		err = NotifyReady()
+		if err != nil {
+			m.stop()
+			return err
+		}
+		ownControl = true
+	} else {
+		go func() {
+			if m.waitReady(ctx) == nil {
+				sdNotify("READY=1")
+			}
+		}()
+	}
+
+	for {
+		select {
+		case c := <-m.exitCh:
+			stop, err := m.handleExit(ctx, c)
+			if stop {
+				m.stop()
+				return err
+			}
+		case w := <-m.respawnCh:
+			if ctx.Err() != nil {
+				continue
+			}
+			err := m.spawn(w)
+			if err != nil {
+				m.stop()
+				return err
+			}
+		case <-sighup:
+			log.Warn("well: got sighup", nil)
+			m.restart(ctx)
+		case req := <-m.controlCh:
+			req.result <- m.restart(ctx)
+		case <-upgrade:
+			log.Warn("well: upgrading master", nil)
+			err := m.upgrade(ctx)
+			if err != nil {
+				log.Error("well: failed to upgrade master", map[string]interface{}{
+					log.FnError: err.Error(),
+				})
+				continue
+			}
+			m.stop()
+			env := g.Env
+			if env == nil {
+				env = defaultEnv
+			}
+			env.Cancel(nil)
+			return nil
+		case <-ctx.Done():
+			m.stop()
+			return nil
+		}
+	}
+}
+
+// ChildStatus represents the status of a child process of Graceful.
+type ChildStatus struct {
+	// Index is the worker index of the child.  See WorkerIndex.
+	Index int `json:"index"`
+
+	// PID is the process ID of the child.
+	// Zero if the child is waiting to be restarted after a crash.
+	PID int `json:"pid"`
+
+	// State is one of "starting", "running", and "crashed".
+	State string `json:"state"`
+
+	// StartAt is the time when the child was started.
+	StartAt time.Time `json:"start_at"`
+
+	// Restarts is the number of times the child has been restarted
+	// by SIGHUP or after crashes.
+	Restarts int `json:"restarts"`
+
+	// Generation is the generation of the child.  See Generation.
+	Generation int `json:"generation"`
+}
+
+// Children returns the status of child processes.
+// This returns nil if g is not running in the master process.
+func (g *Graceful) Children() []ChildStatus {
+	g.mu.Lock()
+	m := g.master
+	g.mu.Unlock()
+	if m == nil {
+		return nil
+	}
+	return m.children()
+}
+
+// children returns the status of child processes.
+func (m *master) children() []ChildStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := make([]ChildStatus, 0, len(m.workers))
+	for _, w := range m.workers {
+		s := ChildStatus{
+			Index:    w.index,
+			Restarts: w.restarts,
+			State:    "crashed",
+		}
+		if c := w.child; c != nil {
+			s.PID = c.cmd.Process.Pid
+			s.StartAt = c.startAt
+			s.Generation = c.generation
+			s.State = "starting"
+			select {
+			case <-c.ready:
+				s.State = "running"
+			default:
+			}
+		}
+		st = append(st, s)
+	}
+	return st
+}
+
+// master manages child processes in the master process.
+type master struct {
+	g      *Graceful
+	logger *log.Logger
+	files  []*os.File
+	names  []string
+
+	// the last npackets files are packet connections.
+	npackets int
+
+	startAt time.Time
+
+	mu      sync.Mutex
+	workers []*worker
+
+	// restarts is the number of completed rolling restarts.
+	restarts int
+
+	// generation is given to new child processes.
+	generation int
+
+	// exitCh receives child processes that have exited.
+	exitCh chan *childProcess
 
-RESTART:
-	child := g.makeChild(files)
-	clog, err := child.StderrPipe()
+	// respawnCh receives workers to be restarted after crashes.
+	respawnCh chan *worker
+
+	// controlCh receives commands from the control socket.
+	controlCh chan *controlRequest
+
+	// controlLn is the listener of the control socket, or nil.
+	controlLn *net.UnixListener
+
+	// quit is closed when runMaster returns.
+	quit chan struct{}
+
+	// upgraded is set when a new master has taken over.
+	upgraded bool
+}
+
+// worker is a slot for a child process.
+type worker struct {
+	index     int
+	child     *childProcess
+	restarter *restarter
+	restarts  int
+}
+
+func newMaster(g *Graceful, files []*os.File, names []string, npackets int) *master {
+	n := g.Workers
+	if n < 1 {
+		n = 1
+	}
+	m := &master{
+		g:          g,
+		logger:     log.DefaultLogger(),
+		files:      files,
+		names:      names,
+		npackets:   npackets,
+		startAt:    time.Now(),
+		generation: 1,
+		workers:    make([]*worker, n),
+		exitCh:     make(chan *childProcess),
+		respawnCh:  make(chan *worker),
+		controlCh:  make(chan *controlRequest),
+		quit:       make(chan struct{}),
+	}
+	for i := range m.workers {
+		w := &worker{index: i}
+		if g.CrashPolicy != nil {
+			w.restarter = newRestarter(*g.CrashPolicy)
+		}
+		m.workers[i] = w
+	}
+	return m
+}
+
+// watch sends c to exitCh when c exits.
+func (m *master) watch(c *childProcess) {
+	go func() {
+		<-c.exited
+		select {
+		case m.exitCh <- c:
+		case <-m.quit:
+		}
+	}()
+}
+
+// spawn starts a child process for w.
+func (m *master) spawn(w *worker) error {
+	c, err := m.startChild(w.index)
 	if err != nil {
 		return err
 	}
-	copyDone := make(chan struct{})
-	// clog will be closed on child.Wait().
-	go copyLog(logger, clog, copyDone)
+	m.mu.Lock()
+	w.child = c
+	m.mu.Unlock()
+	m.watch(c)
+	return nil
+}
 
-	done := make(chan error, 1)
-	err = child.Start()
+// findWorker returns the worker running c, or nil if c has been
+// replaced by another child.
+func (m *master) findWorker(c *childProcess) *worker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.workers {
+		if w.child == c {
+			return w
+		}
+	}
+	return nil
+}
+
+// handleExit handles an exited child process.
+// stop is true if the master should stop with err.
+func (m *master) handleExit(ctx context.Context, c *childProcess) (stop bool, err error) {
+	w := m.findWorker(c)
+	if w == nil {
+		// an old child terminated by restart.
+		return false, nil
+	}
+
+	err = c.err
+	if w.restarter == nil || (err == nil && m.g.CrashPolicy.Mode != RestartAlways) {
+		return true, err
+	}
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	fields := exitFields(err)
+	fields[log.FnType] = "graceful"
+	fields[log.FnStartAt] = c.startAt
+	fields[log.FnResponseTime] = time.Since(c.startAt).Seconds()
+	fields["child_pid"] = c.cmd.Process.Pid
+	fields["worker"] = w.index
+
+	delay, ok := w.restarter.next(c.startAt, time.Now())
+	if !ok {
+		log.Error("well: child crash limit exceeded", fields)
+		if err == nil {
+			err = errors.New("well: child crash limit exceeded")
+		}
+		return true, err
+	}
+	fields["restarts"] = w.restarter.restarts
+	fields["delay"] = delay.Seconds()
+	log.Error("well: child crashed", fields)
+
+	m.mu.Lock()
+	w.child = nil
+	w.restarts++
+	m.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		select {
+		case m.respawnCh <- w:
+		case <-m.quit:
+		}
+	})
+	return false, nil
+}
+
+// restart restarts child processes while notifying systemd.
+func (m *master) restart(ctx context.Context) error {
+	sdNotify(reloadingStates("STATUS=Restarting child processes")...)
+	defer sdNotify("READY=1", "STATUS=")
+
+	m.mu.Lock()
+	m.generation++
+	m.mu.Unlock()
+
+	err := m.rollingRestart(ctx)
 	if err != nil {
 		return err
 	}
+	m.mu.Lock()
+	m.restarts++
+	m.mu.Unlock()
+	return nil
+}
+
+// rollingRestart restarts child processes one by one.
+// If a new child fails to become ready, the old one keeps running
+// and the rest of children are not restarted.
+func (m *master) rollingRestart(ctx context.Context) error {
+	for _, w := range m.workers {
+		m.mu.Lock()
+		old := w.child
+		m.mu.Unlock()
+		if old == nil {
+			// crashed child will be restarted later.
+			continue
+		}
+
+		c, err := m.restartChild(ctx, w.index)
+		if err != nil {
+			log.Error("well: failed to restart child", map[string]interface{}{
+				"old_pid":   old.cmd.Process.Pid,
+				"worker":    w.index,
+				log.FnError: err.Error(),
+			})
+			return err
+		}
+
+		m.mu.Lock()
+		w.child = c
+		w.restarts++
+		m.mu.Unlock()
+		m.watch(c)
+		old.cmd.Process.Signal(syscall.SIGTERM)
+	}
+	return nil
+}
+
+// waitReady waits for all child processes to become ready.
+func (m *master) waitReady(ctx context.Context) error {
+	timeout := m.g.ReadyTimeout
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for _, w := range m.workers {
+		m.mu.Lock()
+		c := w.child
+		m.mu.Unlock()
+		if c == nil {
+			return errors.New("child crashed before ready")
+		}
+
+		select {
+		case <-c.ready:
+		case <-c.exited:
+			return errors.New("child exited before ready")
+		case <-timer.C:
+			return errors.New("timeout waiting for children to be ready")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// upgrade starts a new master process with the listeners, and waits
+// for it to be ready.  If the new master does not become ready,
+// it is stopped and an error is returned.
+func (m *master) upgrade(ctx context.Context) error {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	// stored files may be replaced by serveStore concurrently.
+	stored, err := snapshotStoredFiles()
+	if err != nil {
+		pw.Close()
+		return err
+	}
+	defer stored.close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	files, env := m.childFiles(upgradeEnv, pw, nil, stored)
+	m.mu.Lock()
+	env = append(env, generationEnv+"="+strconv.Itoa(m.generation))
+	m.mu.Unlock()
+	if m.controlLn != nil {
+		f, err := m.controlLn.File()
+		if err != nil {
+			pw.Close()
+			return err
+		}
+		defer f.Close()
+		env = append(env, controlEnv+"="+strconv.Itoa(3+len(files)))
+		files = append(files, f)
+	}
+	cmd.Env = append(watchdogEnviron(os.Environ()), env...)
+	cmd.ExtraFiles = files
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
 	go func() {
-		<-copyDone
-		done <- child.Wait()
+		cmd.Wait()
+		close(exited)
+	}()
+	ready := make(chan struct{})
+	go func() {
+		var buf [1]byte
+		n, _ := pr.Read(buf[:])
+		if n == 1 {
+			close(ready)
+		}
 	}()
 
+	// the new master needs time to start its children in addition.
+	timeout := m.g.ReadyTimeout
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	timer := time.NewTimer(2 * timeout)
+	defer timer.Stop()
+
 	select {
-	case err := <-done:
-		return err
-	case <-sighup:
-		child.Process.Signal(syscall.SIGTERM)
-		log.Warn("well: got sighup", nil)
-		time.Sleep(restartWait)
-		goto RESTART
+	case <-ready:
+		log.Info("well: new master is ready", map[string]interface{}{
+			"new_pid": cmd.Process.Pid,
+		})
+		atomic.StoreInt32(&mainPIDHandedOver, 1)
+		sdNotify("MAINPID=" + strconv.Itoa(cmd.Process.Pid))
+		m.upgraded = true
+		return nil
+	case <-exited:
+		return errors.New("new master exited before ready")
+	case <-timer.C:
+		err = errors.New("timeout waiting for new master to be ready")
 	case <-ctx.Done():
-		child.Process.Signal(syscall.SIGTERM)
-		if g.ExitTimeout == 0 {
-			<-done
-			return nil
+		err = ctx.Err()
+	}
+
+	// roll back; the new master stops its children on SIGTERM.
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+	return err
+}
+
+// stop terminates all child processes and waits for them to exit.
+func (m *master) stop() {
+	m.mu.Lock()
+	var children []*childProcess
+	for _, w := range m.workers {
+		if w.child != nil {
+			children = append(children, w.child)
 		}
+	}
+	m.mu.Unlock()
+
+	for _, c := range children {
+		c.cmd.Process.Signal(syscall.SIGTERM)
+	}
+
+	var timeout <-chan time.Time
+	if m.g.ExitTimeout > 0 {
+		timer := time.NewTimer(m.g.ExitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for _, c := range children {
 		select {
-		case <-done:
-			return nil
-		case <-time.After(g.ExitTimeout):
-			logger.Warn("well: timeout child exit", nil)
-			return nil
+		case <-c.exited:
+		case <-timeout:
+			m.logger.Warn("well: timeout child exit", nil)
+			return
 		}
 	}
 }
 
-func (g *Graceful) makeChild(files []*os.File) *exec.Cmd {
-	child := exec.Command(os.Args[0], os.Args[1:]...)
-	child.Env = os.Environ()
-	child.Env = append(child.Env, listenEnv+"="+strconv.Itoa(len(files)))
-	child.ExtraFiles = files
-	return child
+// childProcess represents a child process started by the master.
+type childProcess struct {
+	cmd        *exec.Cmd
+	startAt    time.Time
+	generation int
+
+	// ready is closed when the child notifies readiness.
+	ready chan struct{}
+
+	// exited is closed when the child exits.
+	// err is the result of Wait of cmd.
+	exited chan struct{}
+	err    error
 }
 
-func copyLog(logger *log.Logger, r io.Reader, done chan<- struct{}) {
-	defer func() {
-		close(done)
-	}()
+// startChild starts a new child process.
+func (m *master) startChild(index int) (*childProcess, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer pw.Close()
 
-	var unwritten []byte
-	buf := make([]byte, 1<<20)
+	// stored files may be replaced by serveStore concurrently.
+	stored, err := snapshotStoredFiles()
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	defer stored.close()
 
-	for {
-		n, err := r.Read(buf)
-		if err != nil {
-			if len(unwritten) == 0 {
-				if n > 0 {
-					logger.WriteThrough(buf[0:n])
-				}
-				return
-			}
-			unwritten = append(unwritten, buf[0:n]...)
-			logger.WriteThrough(unwritten)
-			return
-		}
-		if n == 0 {
-			continue
-		}
-		if buf[n-1] != '\n' {
-			unwritten = append(unwritten, buf[0:n]...)
-			continue
+	// The file store is optional; the child can run without it.
+	storeConn, storeFile, err := newStorePair()
+	if err != nil {
+		m.logger.Warn("well: failed to create socket for file store", map[string]interface{}{
+			log.FnError: err.Error(),
+		})
+	} else {
+		defer storeFile.Close()
+	}
+
+	m.mu.Lock()
+	generation := m.generation
+	m.mu.Unlock()
+
+	cmd, err := m.makeChild(pw, storeFile, stored, index, generation)
+	if err != nil {
+		pr.Close()
+		closeStoreConn(storeConn)
+		return nil, err
+	}
+	// pipes will be closed on cmd.Wait().
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		pr.Close()
+		closeStoreConn(storeConn)
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		pr.Close()
+		closeStoreConn(storeConn)
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		pr.Close()
+		closeStoreConn(storeConn)
+		return nil, err
+	}
+	if storeConn != nil {
+		go serveStore(storeConn)
+	}
+
+	out := newChildOutput(m.logger, m.g.WrapChildOutput, m.g.ChildOutputBuffer, map[string]interface{}{
+		"child_pid":  cmd.Process.Pid,
+		"generation": generation,
+		"worker":     index,
+	})
+	copyDone := out.start(stdout, stderr)
+
+	c := &childProcess{
+		cmd:        cmd,
+		startAt:    time.Now(),
+		generation: generation,
+		ready:      make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+	go func() {
+		<-copyDone
+		c.err = cmd.Wait()
+		closeStoreConn(storeConn)
+		close(c.exited)
+	}()
+	go func() {
+		defer pr.Close()
+		var buf [1]byte
+		n, _ := pr.Read(buf[:])
+		if n == 1 {
+			close(c.ready)
 		}
-		if len(unwritten) == 0 {
-			err = logger.WriteThrough(buf[0:n])
-			if err != nil {
-				return
-			}
-			continue
+	}()
+	return c, nil
+}
+
+// restartChild starts a new child process and waits for it to be ready.
+// If the new child does not become ready, it is killed and an error
+// is returned.
+func (m *master) restartChild(ctx context.Context, index int) (*childProcess, error) {
+	c, err := m.startChild(index)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := m.g.ReadyTimeout
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.ready:
+		log.Info("well: new child is ready", map[string]interface{}{
+			"child_pid": c.cmd.Process.Pid,
+			"worker":    index,
+		})
+		return c, nil
+	case <-c.exited:
+		err := c.err
+		if err == nil {
+			err = errors.New("child exited")
 		}
-		unwritten = append(unwritten, buf[0:n]...)
-		err = logger.WriteThrough(unwritten)
+		return nil, fmt.Errorf("new child exited before ready: %w", err)
+	case <-timer.C:
+		err = errors.New("timeout waiting for new child to be ready")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.cmd.Process.Kill()
+	<-c.exited
+	return nil, err
+}
+
+// exitFields returns log fields describing how a child process exited.
+// err is the result of exec.Cmd.Wait.
+func exitFields(err error) map[string]interface{} {
+	fields := make(map[string]interface{})
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
 		if err != nil {
-			return
+			fields[log.FnError] = err.Error()
+		} else {
+			fields["exit_code"] = 0
 		}
-		unwritten = unwritten[:0]
+		return fields
+	}
+
+	ws, ok := ee.Sys().(syscall.WaitStatus)
+	switch {
+	case ok && ws.Signaled():
+		fields["signal"] = ws.Signal().String()
+	default:
+		fields["exit_code"] = ee.ExitCode()
+	}
+	fields[log.FnError] = err.Error()
+	return fields
+}
+
+// childFiles returns extra files and environment variables to pass
+// the listeners, packet connections, and stored files to a child or
+// a new master.  The number of listeners is set to envvar.
+// storeFile may be nil.  stored must be kept open until the process
+// is started.
+func (m *master) childFiles(envvar string, readyPipe, storeFile *os.File, stored *storedFileSet) ([]*os.File, []string) {
+	files := append([]*os.File(nil), m.files...)
+	env := []string{
+		envvar + "=" + strconv.Itoa(len(m.files)-m.npackets),
+		packetEnv + "=" + strconv.Itoa(m.npackets),
+	}
+	if m.names != nil {
+		env = append(env, listenNamesEnv+"="+strings.Join(m.names, ":"))
 	}
+
+	// ExtraFiles[i] becomes fd 3+i.
+	env = append(env, readyEnv+"="+strconv.Itoa(3+len(files)))
+	files = append(files, readyPipe)
+	if storeFile != nil {
+		env = append(env, storeEnv+"="+strconv.Itoa(3+len(files)))
+		files = append(files, storeFile)
+	}
+
+	if len(stored.files) > 0 {
+		env = append(env, storedFdsEnv+"="+strconv.Itoa(3+len(files)))
+		env = append(env, storedNamesEnv+"="+strings.Join(stored.names, ":"))
+		files = append(files, stored.files...)
+	}
+	return files, env
+}
+
+func (m *master) makeChild(readyPipe, storeFile *os.File, stored *storedFileSet, index, generation int) (*exec.Cmd, error) {
+	child := exec.Command(os.Args[0], os.Args[1:]...)
+	files, env := m.childFiles(listenEnv, readyPipe, storeFile, stored)
+	files, env, err := m.inheritFiles(files, env, generation)
+	if err != nil {
+		return nil, err
+	}
+	child.Env = os.Environ()
+	child.Env = append(child.Env, env...)
+	child.Env = append(child.Env, workerEnv+"="+strconv.Itoa(index))
+	child.ExtraFiles = files
+	return child, nil
 }
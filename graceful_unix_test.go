@@ -4,8 +4,13 @@
 package well
 
 import (
+	"io"
 	"net"
+	"os"
+	"os/exec"
 	"runtime"
+	"strconv"
+	"syscall"
 	"testing"
 )
 
@@ -29,3 +34,139 @@ func TestListenerFiles(t *testing.T) {
 		t.Error(`len(fl) != 1`)
 	}
 }
+
+func TestNotifyReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fd, err := syscall.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	t.Setenv(readyEnv, strconv.Itoa(fd))
+	err = restoreReadyPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NotifyReady()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// second call does nothing.
+	err = NotifyReady()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 {
+		t.Error(`len(data) != 1`, data)
+	}
+}
+
+func TestExitFields(t *testing.T) {
+	t.Parallel()
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	fields := exitFields(err)
+	if fields["exit_code"] != 3 {
+		t.Error(`fields["exit_code"] != 3`, fields)
+	}
+
+	err = exec.Command("sh", "-c", "kill -TERM $$").Run()
+	fields = exitFields(err)
+	if fields["signal"] != syscall.SIGTERM.String() {
+		t.Error(`fields["signal"] != syscall.SIGTERM.String()`, fields)
+	}
+
+	fields = exitFields(nil)
+	if fields["exit_code"] != 0 {
+		t.Error(`fields["exit_code"] != 0`, fields)
+	}
+}
+
+func TestWorkerIndex(t *testing.T) {
+	t.Setenv(workerEnv, "")
+	if WorkerIndex() != 0 {
+		t.Error(`WorkerIndex() != 0`)
+	}
+
+	t.Setenv(workerEnv, "3")
+	if WorkerIndex() != 3 {
+		t.Error(`WorkerIndex() != 3`)
+	}
+
+	g := &Graceful{}
+	if g.Children() != nil {
+		t.Error(`g.Children() != nil`)
+	}
+}
+
+func TestFileSocket(t *testing.T) {
+	t.Parallel()
+
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	files, err := packetConnFiles([]net.PacketConn{pc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfiles, err := listenerFiles([]net.Listener{ln})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fileSocket takes the ownership of fds.
+	pfd, err := syscall.Dup(int(files[0].Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[0].Close()
+	lfd, err := syscall.Dup(int(lfiles[0].Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lfiles[0].Close()
+
+	l, c, err := fileSocket(pfd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l != nil || c == nil {
+		t.Fatal("datagram socket should be restored as PacketConn")
+	}
+	defer c.Close()
+	if c.LocalAddr().String() != pc.LocalAddr().String() {
+		t.Error(`c.LocalAddr().String() != pc.LocalAddr().String()`)
+	}
+
+	l, c, err = fileSocket(lfd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l == nil || c != nil {
+		t.Fatal("stream socket should be restored as Listener")
+	}
+	defer l.Close()
+	if l.Addr().String() != ln.Addr().String() {
+		t.Error(`l.Addr().String() != ln.Addr().String()`)
+	}
+}
@@ -3,7 +3,13 @@
 
 package well
 
-import "net"
+import (
+	"net"
+	"os"
+	"time"
+)
+
+type master struct{}
 
 func isMaster() bool {
 	return true
@@ -14,6 +20,56 @@ func SystemdListeners() ([]net.Listener, error) {
 	return nil, nil
 }
 
+// WorkerIndex always returns 0 on Windows.
+func WorkerIndex() int {
+	return 0
+}
+
+// Generation always returns 0 on Windows.
+func Generation() int {
+	return 0
+}
+
+// InheritedFile always returns nil on Windows.
+func InheritedFile(name string) *os.File {
+	return nil
+}
+
+// InheritedState always returns nil on Windows.
+func InheritedState() []byte {
+	return nil
+}
+
+// ChildStatus represents the status of a child process of Graceful.
+type ChildStatus struct {
+	Index      int       `json:"index"`
+	PID        int       `json:"pid"`
+	State      string    `json:"state"`
+	StartAt    time.Time `json:"start_at"`
+	Restarts   int       `json:"restarts"`
+	Generation int       `json:"generation"`
+}
+
+// Children returns nil on Windows.
+func (g *Graceful) Children() []ChildStatus {
+	return nil
+}
+
+// NotifyReady does nothing on Windows.
+func NotifyReady() error {
+	return nil
+}
+
+// SystemdNamedListeners returns (nil, nil) on Windows.
+func SystemdNamedListeners() (map[string]net.Listener, error) {
+	return nil, nil
+}
+
+// SystemdPacketConns returns (nil, nil) on Windows.
+func SystemdPacketConns() ([]net.PacketConn, error) {
+	return nil, nil
+}
+
 // Run simply calls g.Listen then g.Serve on Windows.
 func (g *Graceful) Run() {
 	env := g.Env
@@ -22,10 +78,15 @@ func (g *Graceful) Run() {
 	}
 
 	// prepare listener files
-	listeners, err := g.Listen()
+	names, listeners, conns, err := g.listen()
+	if err != nil {
+		env.Cancel(err)
+		return
+	}
+	serve, err := g.serveFunc(names, listeners, conns)
 	if err != nil {
 		env.Cancel(err)
 		return
 	}
-	g.Serve(listeners)
+	serve()
 }
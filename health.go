@@ -0,0 +1,46 @@
+package well
+
+import (
+	"context"
+)
+
+type healthCheck struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+// AddHealthCheck registers a function to check the health of
+// the program.  f should return non-nil error if the program is
+// unhealthy, e.g. when a goroutine does not make progress.
+//
+// Health checks of the global environment are used to send
+// keepalives to systemd watchdog.  See SystemdNotify.
+func (e *Environment) AddHealthCheck(name string, f func(ctx context.Context) error) {
+	e.mu.Lock()
+	e.healthChecks = append(e.healthChecks, healthCheck{name, f})
+	e.mu.Unlock()
+}
+
+// CheckHealth calls the functions registered by AddHealthCheck
+// in the order of registration.
+//
+// It returns an error that contains all errors returned from
+// the functions, or nil if all of them pass.  Each error is
+// wrapped in *TaskError having the name of the check.
+func (e *Environment) CheckHealth(ctx context.Context) error {
+	e.mu.RLock()
+	checks := e.healthChecks
+	e.mu.RUnlock()
+
+	var errs []error
+	for _, c := range checks {
+		err := c.f(ctx)
+		if err != nil {
+			errs = append(errs, &TaskError{Name: c.name, Err: err})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &joinError{errs}
+}
@@ -0,0 +1,45 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckHealth(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	if err := env.CheckHealth(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	errTest := errors.New("unhealthy")
+	healthy := true
+	env.AddHealthCheck("ok", func(ctx context.Context) error {
+		return nil
+	})
+	env.AddHealthCheck("flag", func(ctx context.Context) error {
+		if healthy {
+			return nil
+		}
+		return errTest
+	})
+
+	if err := env.CheckHealth(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	healthy = false
+	err := env.CheckHealth(context.Background())
+	if !errors.Is(err, errTest) {
+		t.Error(`!errors.Is(err, errTest)`, err)
+	}
+	var te *TaskError
+	if !errors.As(err, &te) {
+		t.Fatal(`!errors.As(err, &te)`)
+	}
+	if te.Name != "flag" {
+		t.Error(`te.Name != "flag"`, te.Name)
+	}
+}
@@ -189,6 +189,8 @@ func createLogWriter(w http.ResponseWriter) (http.ResponseWriter, logWriter) {
 // ServeHTTP implements http.Handler interface.
 func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	addInflight(1)
+	defer addInflight(-1)
 
 	w, lw := createLogWriter(w)
 
@@ -257,7 +259,8 @@ func (s *HTTPServer) init() {
 		s.Env = defaultEnv
 	}
 
-	s.Env.Go(s.wait)
+	labels := map[string]string{"addr": s.Server.Addr}
+	s.Env.GoInPhase(PhaseStopAccepting, "well.HTTPServer", labels, s.wait)
 }
 
 func (s *HTTPServer) wait(ctx context.Context) error {
@@ -299,6 +302,7 @@ func (s *HTTPServer) TimedOut() bool {
 // Serve always returns nil.
 func (s *HTTPServer) Serve(l net.Listener) error {
 	s.initOnce.Do(s.init)
+	serverStarted(s.Env)
 
 	l = netutil.KeepAliveListener(l)
 
@@ -121,6 +121,8 @@ func (w *logResponseWriter) WriteString(data string) (int, error) {
 // ServeHTTP implements http.Handler interface.
 func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	addInflight(1)
+	defer addInflight(-1)
 
 	lw := &logResponseWriter{w.(StdResponseWriter), http.StatusOK, 0}
 	ctx, cancel := context.WithCancel(s.Env.ctx)
@@ -208,7 +210,8 @@ func (s *HTTPServer) init() {
 	if s.Env == nil {
 		s.Env = defaultEnv
 	}
-	s.Env.Go(s.wait)
+	labels := map[string]string{"addr": s.Server.Addr}
+	s.Env.GoInPhase(PhaseStopAccepting, "well.HTTPServer", labels, s.wait)
 }
 
 func (s *HTTPServer) wait(ctx context.Context) error {
@@ -274,6 +277,7 @@ func (s *HTTPServer) TimedOut() bool {
 // Serve always returns nil.
 func (s *HTTPServer) Serve(l net.Listener) error {
 	s.initOnce.Do(s.init)
+	serverStarted(s.Env)
 
 	l = netutil.KeepAliveListener(l)
 
@@ -0,0 +1,142 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	generationEnv     = "CYBOZU_GENERATION"
+	inheritedFdsEnv   = "CYBOZU_INHERITED_FDS"
+	inheritedNamesEnv = "CYBOZU_INHERITED_FDNAMES"
+	inheritedStateEnv = "CYBOZU_INHERITED_STATE"
+
+	// maxChildStateSize limits the size of Graceful.ChildState as
+	// it is passed by an environment variable.
+	maxChildStateSize = 64 * 1024
+)
+
+var (
+	inheritMu      sync.Mutex
+	inheritedFiles map[string]*os.File
+	inheritedState []byte
+)
+
+// Generation returns the generation of this child process of Graceful.
+//
+// The generation starts from 1, and increases every time child
+// processes are restarted by SIGHUP or the restart command of the
+// control socket.  Children restarted after crashes keep the current
+// generation.  A new master started by ActionUpgrade continues
+// the generation of the old master.
+//
+// The generation is also available as CYBOZU_GENERATION environment variable.
+// This returns 0 if the process is not a child of Graceful.
+func Generation() int {
+	if isMaster() {
+		return 0
+	}
+	gen, _ := strconv.Atoi(os.Getenv(generationEnv))
+	return gen
+}
+
+// InheritedFile returns the file passed by Graceful.ChildFiles with name,
+// or nil if not found.  The returned file is owned by the framework;
+// do not close it.
+func InheritedFile(name string) *os.File {
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	return inheritedFiles[name]
+}
+
+// InheritedState returns the data returned from Graceful.ChildState
+// in the master process, or nil if there is no such data.
+func InheritedState() []byte {
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	return inheritedState
+}
+
+// restoreInherited restores files and state passed from the master.
+func restoreInherited() error {
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+
+	if v := os.Getenv(inheritedStateEnv); len(v) > 0 {
+		os.Unsetenv(inheritedStateEnv)
+		data, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return err
+		}
+		inheritedState = data
+	}
+
+	v := os.Getenv(inheritedFdsEnv)
+	if len(v) == 0 {
+		return nil
+	}
+	os.Unsetenv(inheritedFdsEnv)
+	names := restoreNames(inheritedNamesEnv)
+	offset, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	inheritedFiles = make(map[string]*os.File, len(names))
+	for i, name := range names {
+		fd := offset + i
+		syscall.CloseOnExec(fd)
+		inheritedFiles[name] = os.NewFile(uintptr(fd), name)
+	}
+	return nil
+}
+
+// inheritFiles appends files and environment variables to pass
+// Graceful.ChildFiles, Graceful.ChildState, and generation to a child.
+func (m *master) inheritFiles(files []*os.File, env []string, generation int) ([]*os.File, []string, error) {
+	env = append(env, generationEnv+"="+strconv.Itoa(generation))
+
+	if m.g.ChildState != nil {
+		data, err := m.g.ChildState()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(data) > maxChildStateSize {
+			return nil, nil, errors.New("too large child state: " + strconv.Itoa(len(data)))
+		}
+		if len(data) > 0 {
+			env = append(env, inheritedStateEnv+"="+base64.StdEncoding.EncodeToString(data))
+		}
+	}
+
+	if m.g.ChildFiles != nil {
+		fm, err := m.g.ChildFiles()
+		if err != nil {
+			return nil, nil, err
+		}
+		names := make([]string, 0, len(fm))
+		for name := range fm {
+			if len(name) == 0 || strings.Contains(name, ":") {
+				return nil, nil, errors.New("invalid file name: " + name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			env = append(env, inheritedFdsEnv+"="+strconv.Itoa(3+len(files)))
+			env = append(env, inheritedNamesEnv+"="+strings.Join(names, ":"))
+			for _, name := range names {
+				files = append(files, fm[name])
+			}
+		}
+	}
+	return files, env, nil
+}
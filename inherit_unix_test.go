@@ -0,0 +1,116 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestInheritFiles(t *testing.T) {
+	t.Parallel()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	g := &Graceful{
+		ChildFiles: func() (map[string]*os.File, error) {
+			return map[string]*os.File{"w": w, "r": r}, nil
+		},
+		ChildState: func() ([]byte, error) {
+			return []byte("gen-config"), nil
+		},
+	}
+	m := newMaster(g, nil, nil, 0)
+
+	files, env, err := m.inheritFiles([]*os.File{w}, []string{"FOO=bar"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []*os.File{w, r, w}) {
+		t.Error("unexpected files:", files)
+	}
+	expected := []string{
+		"FOO=bar",
+		generationEnv + "=3",
+		inheritedStateEnv + "=Z2VuLWNvbmZpZw==",
+		inheritedFdsEnv + "=4",
+		inheritedNamesEnv + "=r:w",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Error("unexpected env:", env)
+	}
+
+	g.ChildState = func() ([]byte, error) {
+		return make([]byte, maxChildStateSize+1), nil
+	}
+	_, _, err = m.inheritFiles(nil, nil, 1)
+	if err == nil {
+		t.Error("too large state should cause an error")
+	}
+
+	g.ChildState = nil
+	g.ChildFiles = func() (map[string]*os.File, error) {
+		return map[string]*os.File{"a:b": r}, nil
+	}
+	_, _, err = m.inheritFiles(nil, nil, 1)
+	if err == nil {
+		t.Error("invalid name should cause an error")
+	}
+}
+
+func TestRestoreInherited(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fd, err := syscall.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	t.Setenv(inheritedStateEnv, "Z2VuLWNvbmZpZw==")
+	t.Setenv(inheritedFdsEnv, strconv.Itoa(fd))
+	t.Setenv(inheritedNamesEnv, "pipe")
+	err = restoreInherited()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(InheritedState(), []byte("gen-config")) {
+		t.Error("unexpected state:", string(InheritedState()))
+	}
+	f := InheritedFile("pipe")
+	if f == nil {
+		t.Fatal("file is not inherited")
+	}
+	defer f.Close()
+	if InheritedFile("foo") != nil {
+		t.Error("unknown file should be nil")
+	}
+
+	_, err = f.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_, err = r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Error("unexpected data:", string(buf))
+	}
+}
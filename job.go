@@ -0,0 +1,147 @@
+package well
+
+import (
+	"context"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+// Job is a function executed periodically in an Environment.
+//
+// A run of the job is skipped if the previous run is still running
+// at the scheduled time.
+type Job struct {
+	// Name is the name of the job.
+	Name string
+
+	// Schedule determines when the job runs.  This must not be nil.
+	//
+	// Use Every or ParseCron to create one.
+	Schedule Schedule
+
+	// Func is the function to be executed.  This must not be nil.
+	//
+	// ctx is a derived context from the base context and has a new
+	// request tracking ID for each run.
+	//
+	// Unlike Go, errors returned from Func are just logged and
+	// do not cancel the environment.
+	Func func(ctx context.Context) error
+
+	// Jitter delays each run randomly up to the given duration.
+	//
+	// Zero disables jitter.
+	Jitter time.Duration
+
+	// Timeout is the maximum duration of each run.
+	// The context passed to Func will be canceled after Timeout.
+	//
+	// Zero disables timeout.
+	Timeout time.Duration
+
+	// Severity is used to log successful runs.
+	//
+	// Zero suppresses logging.  Valid values are one of
+	// log.LvDebug, log.LvInfo, and so on.
+	//
+	// Errors are always logged with log.LvError.
+	Severity int
+
+	// Logger for job execution results.  If nil, the default logger is used.
+	Logger *log.Logger
+
+	// Env is the environment where this job runs.
+	//
+	// The global environment is used if Env is nil.
+	Env *Environment
+}
+
+// Start starts a managed goroutine to run the job according to
+// j.Schedule.
+//
+// Start itself returns immediately.  The goroutine continues
+// to run the job until the base context is canceled.
+func (j *Job) Start() {
+	env := j.Env
+	if env == nil {
+		env = defaultEnv
+	}
+	if j.Schedule == nil || j.Func == nil {
+		panic("Schedule and Func must not be nil")
+	}
+
+	labels := map[string]string{"type": "job"}
+	env.GoWithName(j.Name, labels, func(ctx context.Context) error {
+		next := j.Schedule.Next(time.Now())
+		for !next.IsZero() {
+			timer := time.NewTimer(time.Until(next) + randomDuration(j.Jitter))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+
+			j.run(ctx, env)
+
+			// skip runs scheduled while the job was running.
+			now := time.Now()
+			skipped := 0
+			next = j.Schedule.Next(next)
+			for !next.IsZero() && !next.After(now) {
+				next = j.Schedule.Next(next)
+				skipped++
+			}
+			if skipped > 0 {
+				log.Warn("well: job runs skipped", map[string]interface{}{
+					"name":    j.Name,
+					"skipped": skipped,
+				})
+			}
+		}
+		return nil
+	})
+}
+
+func (j *Job) run(ctx context.Context, env *Environment) {
+	env.mu.RLock()
+	recoverPanic := env.recoverPanic
+	env.mu.RUnlock()
+
+	id := env.generator.Generate()
+	ctx = WithRequestID(ctx, id)
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+
+	st := time.Now()
+	err := runTask(ctx, &Task{Name: j.Name, RequestID: id}, recoverPanic, j.Func)
+
+	logger := j.Logger
+	if logger == nil {
+		logger = log.DefaultLogger()
+	}
+
+	if err == nil && (j.Severity == 0 || !logger.Enabled(j.Severity)) {
+		// successful logs are suppressed if j.Severity is 0 or
+		// logger threshold is under j.Severity.
+		return
+	}
+
+	fields := FieldsFromContext(ctx)
+	fields[log.FnType] = "job"
+	fields[log.FnResponseTime] = time.Since(st).Seconds()
+	fields[log.FnStartAt] = st
+	fields["name"] = j.Name
+
+	if err == nil {
+		logger.Log(j.Severity, "well: job", fields)
+		return
+	}
+
+	fields["error"] = err.Error()
+	logger.Error("well: job", fields)
+}
@@ -0,0 +1,108 @@
+package well
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+func TestJob(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	var count int32
+	ids := make(chan interface{}, 10)
+	j := &Job{
+		Name:     "test",
+		Schedule: Every(10 * time.Millisecond),
+		Func: func(ctx context.Context) error {
+			ids <- ctx.Value(RequestIDContextKey)
+			if atomic.AddInt32(&count, 1) == 3 {
+				env.Cancel(nil)
+			}
+			return nil
+		},
+		Env: env,
+	}
+	j.Start()
+
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Error(`count != 3`, count)
+	}
+
+	id1 := <-ids
+	id2 := <-ids
+	if id1 == nil || id2 == nil {
+		t.Fatal(`no request ID`)
+	}
+	if id1 == id2 {
+		t.Error(`id1 == id2`)
+	}
+}
+
+func TestJobSkipAndTimeout(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	logger := log.NewLogger()
+	logger.SetFormatter(log.JSONFormat{})
+	buf := new(bytes.Buffer)
+	logger.SetOutput(buf)
+
+	var count int32
+	j := &Job{
+		Name:     "slow",
+		Schedule: Every(10 * time.Millisecond),
+		Timeout:  50 * time.Millisecond,
+		Func: func(ctx context.Context) error {
+			atomic.AddInt32(&count, 1)
+			<-ctx.Done()
+			env.Cancel(nil)
+			return errors.New("timeout")
+		},
+		Logger: logger,
+		Env:    env,
+	}
+	j.Start()
+
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error(`count != 1`, count)
+	}
+
+	var joblog JobLog
+	err = json.Unmarshal(buf.Bytes(), &joblog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joblog.Severity != "error" {
+		t.Error(`joblog.Severity != "error"`)
+	}
+	if joblog.Type != "job" {
+		t.Error(`joblog.Type != "job"`)
+	}
+	if joblog.Name != "slow" {
+		t.Error(`joblog.Name != "slow"`)
+	}
+	if joblog.Elapsed < 0.05 {
+		t.Error(`joblog.Elapsed < 0.05`, joblog.Elapsed)
+	}
+	if len(joblog.RequestID) != 36 {
+		t.Error(`len(joblog.RequestID) != 36`)
+	}
+}
@@ -61,3 +61,20 @@ type ExecLog struct {
 	Error     string   `json:"error"`
 	Stderr    string   `json:"stderr"`
 }
+
+// JobLog is a struct to decode job execution log from Job.
+// The struct is tagged for JSON format.
+type JobLog struct {
+	Topic    string    `json:"topic"`
+	LoggedAt time.Time `json:"logged_at"`
+	Severity string    `json:"severity"` // "error" if the job failed.
+	Utsname  string    `json:"utsname"`
+	Message  string    `json:"message"`
+
+	Type      string    `json:"type"`          // "job"
+	Elapsed   float64   `json:"response_time"` // floating point number of seconds.
+	StartAt   time.Time `json:"start_at"`
+	Name      string    `json:"name"`
+	RequestID string    `json:"request_id"`
+	Error     string    `json:"error"`
+}
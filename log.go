@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"io"
+	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/cybozu-go/log"
 	"github.com/spf13/pflag"
@@ -18,6 +21,11 @@ var (
 	logFormat   = flag.String("logformat", "", "Log format [plain,logfmt,json]")
 
 	ignoreLogFilename bool
+
+	// the log file opened by LogConfig.Apply.
+	currentLogMu       sync.Mutex
+	currentLogFilename string
+	currentLogWriter   io.WriteCloser
 )
 
 func init() {
@@ -65,6 +73,12 @@ type LogConfig struct {
 //   - log.format
 //
 // If they are not empty, they take precedence over the struct member values.
+//
+// Apply can be called again, e.g. from a callback registered by OnReload,
+// to change the configurations without restarting the program.
+// If the output filename is changed, the new file is opened and then
+// the old file is closed.  If the filename becomes empty, logs are
+// written to standard error again.
 func (c LogConfig) Apply() error {
 	logger := log.DefaultLogger()
 
@@ -75,16 +89,11 @@ func (c LogConfig) Apply() error {
 	if v := viper.GetString("log.file"); len(v) > 0 {
 		filename = v
 	}
-	if len(filename) > 0 && !ignoreLogFilename {
-		abspath, err := filepath.Abs(filename)
+	if !ignoreLogFilename {
+		err := setLogFile(logger, filename)
 		if err != nil {
 			return err
 		}
-		w, err := openLogFile(abspath)
-		if err != nil {
-			return err
-		}
-		logger.SetOutput(w)
 	}
 
 	level := c.Level
@@ -123,6 +132,46 @@ func (c LogConfig) Apply() error {
 	return nil
 }
 
+// setLogFile switches the output of logger to filename.
+// If filename is empty, the output is switched back to os.Stderr
+// only when a file has been set by setLogFile.
+func setLogFile(logger *log.Logger, filename string) error {
+	if len(filename) > 0 {
+		abspath, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		filename = abspath
+	}
+
+	currentLogMu.Lock()
+	defer currentLogMu.Unlock()
+
+	if filename == currentLogFilename {
+		return nil
+	}
+
+	old := currentLogWriter
+	if len(filename) == 0 {
+		logger.SetOutput(os.Stderr)
+		currentLogFilename = ""
+		currentLogWriter = nil
+	} else {
+		w, err := openLogFile(filename)
+		if err != nil {
+			return err
+		}
+		logger.SetOutput(w)
+		currentLogFilename = filename
+		currentLogWriter = w
+	}
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
 // FieldsFromContext returns a map of fields containing
 // context information.  Currently, request ID field is
 // included, if any.
@@ -9,6 +9,7 @@ import (
 	"flag"
 	"io"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
@@ -97,6 +98,66 @@ func TestLogConfigApply(t *testing.T) {
 	}
 }
 
+func TestSetLogFile(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "log1")
+	file2 := filepath.Join(dir, "log2")
+
+	logger := log.NewLogger()
+	defer setLogFile(logger, "")
+
+	err := setLogFile(logger, file1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Error("message1", nil)
+
+	err = setLogFile(logger, file1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Error("message2", nil)
+
+	err = setLogFile(logger, file2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Error("message3", nil)
+
+	data1, err := os.ReadFile(file1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data2, err := os.ReadFile(file2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data1, []byte("message1")) || !bytes.Contains(data1, []byte("message2")) {
+		t.Error("file1 lacks messages:", string(data1))
+	}
+	if bytes.Contains(data1, []byte("message3")) {
+		t.Error("file1 contains message3")
+	}
+	if !bytes.Contains(data2, []byte("message3")) {
+		t.Error("file2 lacks message3:", string(data2))
+	}
+
+	logFilesMu.Lock()
+	n := len(logFiles)
+	logFilesMu.Unlock()
+	if n != 1 {
+		t.Error(`n != 1`, n)
+	}
+
+	err = setLogFile(logger, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currentLogWriter != nil {
+		t.Error(`currentLogWriter != nil`)
+	}
+}
+
 func TestLogFlags(t *testing.T) {
 	t.Parallel()
 	t.Skip("this test redirects log outputs to a temp file.")
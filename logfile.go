@@ -6,10 +6,13 @@ package well
 import (
 	"io"
 	"syscall"
-
-	"github.com/cybozu-go/log"
 )
 
-func openLogFile(filename string) (io.Writer, error) {
-	return log.NewFileReopener(filename, syscall.SIGUSR1)
+func openLogFile(filename string) (io.WriteCloser, error) {
+	w, err := newLogFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	setDefaultSignal(syscall.SIGUSR1, ActionReopenLogs)
+	return w, nil
 }
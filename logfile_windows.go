@@ -5,6 +5,6 @@ import (
 	"os"
 )
 
-func openLogFile(filename string) (io.Writer, error) {
+func openLogFile(filename string) (io.WriteCloser, error) {
 	return os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 }
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package well
+
+// monotonicUsec returns 0 as systemd runs only on Linux.
+func monotonicUsec() int64 {
+	return 0
+}
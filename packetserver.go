@@ -0,0 +1,148 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+const (
+	defaultPacketBufferSize = 65535
+)
+
+// PacketServer is a generic network server for packet connections
+// such as UDP.  It reads datagrams and invokes Handler in a goroutine
+// for each datagram.
+//
+// In addition, Serve method gracefully waits all its goroutines to
+// complete before returning.
+type PacketServer struct {
+
+	// Handler handles a datagram.  This must not be nil.
+	//
+	// ctx is a derived context from the base context that will be
+	// canceled when Handler returns.
+	//
+	// conn is the connection that received the datagram.  Handler may
+	// use it to send responses to addr.  data is the content of the
+	// datagram and is owned by Handler.
+	Handler func(ctx context.Context, conn net.PacketConn, data []byte, addr net.Addr)
+
+	// BufferSize is the maximum size of datagrams.
+	// Longer datagrams are truncated.
+	//
+	// Zero means 65535.
+	BufferSize int
+
+	// ShutdownTimeout is the maximum duration the server waits for
+	// all handlers to return before shutdown.
+	//
+	// Zero duration disables timeout.
+	ShutdownTimeout time.Duration
+
+	// Env is the environment where this server runs.
+	//
+	// The global environment is used if Env is nil.
+	Env *Environment
+
+	wg       sync.WaitGroup
+	timedout int32
+}
+
+// Serve starts a managed goroutine to read datagrams from conn.
+//
+// Serve itself returns immediately.  The goroutine continues
+// to read and handle datagrams until the base context is canceled,
+// or reading from conn fails.
+//
+// The connection conn will be closed automatically after the
+// environment's Cancel is called and all handlers return, so that
+// handlers can send responses during the graceful shutdown.
+func (s *PacketServer) Serve(conn net.PacketConn) {
+	env := s.Env
+	if env == nil {
+		env = defaultEnv
+	}
+	serverStarted(env)
+
+	// unblock ReadFrom without closing conn.
+	go func() {
+		<-env.ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	bufSize := s.BufferSize
+	if bufSize <= 0 {
+		bufSize = defaultPacketBufferSize
+	}
+
+	labels := map[string]string{"addr": conn.LocalAddr().String()}
+	env.GoInPhase(PhaseStopAccepting, "well.PacketServer", labels, func(ctx context.Context) error {
+		generator := NewIDGenerator()
+		buf := make([]byte, bufSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					log.Error("well: PacketConn.ReadFrom error", map[string]interface{}{
+						"addr":  conn.LocalAddr().String(),
+						"error": err.Error(),
+					})
+				}
+				break
+			}
+
+			// buf is reused for the next datagram.
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			s.wg.Add(1)
+			addInflight(1)
+			go func() {
+				ctx, cancel := context.WithCancel(ctx)
+				defer func() {
+					cancel()
+					addInflight(-1)
+				}()
+				ctx = WithRequestID(ctx, generator.Generate())
+				s.Handler(ctx, conn, data, addr)
+				s.wg.Done()
+			}()
+		}
+
+		s.wait()
+		conn.Close()
+		return nil
+	})
+}
+
+func (s *PacketServer) wait() {
+	if s.ShutdownTimeout == 0 {
+		s.wg.Wait()
+		return
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(ch)
+	}()
+
+	select {
+	case <-ch:
+	case <-time.After(s.ShutdownTimeout):
+		log.Warn("well: timeout waiting for shutdown", nil)
+		atomic.StoreInt32(&s.timedout, 1)
+	}
+}
+
+// TimedOut returns true if the server shut down before all handlers
+// returned.
+func (s *PacketServer) TimedOut() bool {
+	return atomic.LoadInt32(&s.timedout) != 0
+}
@@ -0,0 +1,168 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPacketServer(t *testing.T) {
+	t.Parallel()
+
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	handler := func(ctx context.Context, conn net.PacketConn, data []byte, addr net.Addr) {
+		if ctx.Value(RequestIDContextKey) == nil {
+			// handler must receive a new request ID.
+			return
+		}
+		conn.WriteTo(append([]byte("echo "), data...), addr)
+	}
+
+	env := NewEnvironment(context.Background())
+	s := &PacketServer{
+		Handler: handler,
+		Env:     env,
+	}
+	s.Serve(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "echo hello" {
+		t.Error(`string(buf[:n]) != "echo hello"`, string(buf[:n]))
+	}
+
+	env.Cancel(nil)
+	err = env.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s.TimedOut() {
+		t.Error(`s.TimedOut()`)
+	}
+}
+
+func TestPacketServerReplyOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(ctx context.Context, conn net.PacketConn, data []byte, addr net.Addr) {
+		close(started)
+		<-release
+		conn.WriteTo(append([]byte("echo "), data...), addr)
+	}
+
+	env := NewEnvironment(context.Background())
+	s := &PacketServer{
+		Handler: handler,
+		Env:     env,
+	}
+	s.Serve(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// the handler replies during the graceful shutdown.
+	env.Cancel(nil)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "echo hello" {
+		t.Error(`string(buf[:n]) != "echo hello"`, string(buf[:n]))
+	}
+
+	err = env.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// errorPacketConn is a net.PacketConn that always fails to read.
+type errorPacketConn struct {
+	net.PacketConn
+	reads  int32
+	closed int32
+}
+
+func (c *errorPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	atomic.AddInt32(&c.reads, 1)
+	return 0, nil, errors.New("persistent error")
+}
+
+func (c *errorPacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+func (c *errorPacketConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *errorPacketConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func TestPacketServerReadError(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	s := &PacketServer{
+		Handler: func(ctx context.Context, conn net.PacketConn, data []byte, addr net.Addr) {},
+		Env:     env,
+	}
+	c := &errorPacketConn{}
+	s.Serve(c)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&c.reads); n != 1 {
+		t.Error(`server should stop on read errors`, n)
+	}
+	if atomic.LoadInt32(&c.closed) == 0 {
+		t.Error(`conn should be closed`)
+	}
+
+	env.Cancel(nil)
+	env.Wait()
+}
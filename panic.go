@@ -0,0 +1,70 @@
+package well
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/cybozu-go/log"
+)
+
+// PanicError is an error converted from a panic in a goroutine
+// started by Environment.
+//
+// Panics are converted only if panic recovery is enabled by
+// Environment.EnablePanicRecovery.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the panicked goroutine.
+	Stack []byte
+
+	// Name is the name of the goroutine, if any.
+	Name string
+
+	// RequestID is the request tracking ID of the goroutine, if any.
+	RequestID string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns Value if it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// IsPanic returns true if err returned by Wait indicates that
+// a goroutine has panicked.
+func IsPanic(err error) bool {
+	var perr *PanicError
+	return errors.As(err, &perr)
+}
+
+func newPanicError(t *Task, v interface{}) *PanicError {
+	perr := &PanicError{
+		Value:     v,
+		Stack:     debug.Stack(),
+		Name:      t.Name,
+		RequestID: t.RequestID,
+	}
+
+	fields := map[string]interface{}{
+		"panic": fmt.Sprint(v),
+		"stack": string(perr.Stack),
+	}
+	if len(t.Name) > 0 {
+		fields["name"] = t.Name
+	}
+	if len(t.RequestID) > 0 {
+		fields[log.FnRequestID] = t.RequestID
+	}
+	log.Error("well: panic", fields)
+
+	return perr
+}
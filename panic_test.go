@@ -0,0 +1,57 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEnvironmentPanic(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	env.EnablePanicRecovery()
+
+	env.GoWithName("panicker", nil, func(ctx context.Context) error {
+		panic("test panic")
+	})
+	env.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	err := env.Wait()
+	if !IsPanic(err) {
+		t.Fatal(`!IsPanic(err)`, err)
+	}
+
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Fatal(`!errors.As(err, &perr)`)
+	}
+	if perr.Value != "test panic" {
+		t.Error(`perr.Value != "test panic"`)
+	}
+	if perr.Name != "panicker" {
+		t.Error(`perr.Name != "panicker"`)
+	}
+	if len(perr.RequestID) != 36 {
+		t.Error(`len(perr.RequestID) != 36`)
+	}
+	if len(perr.Stack) == 0 {
+		t.Error(`len(perr.Stack) == 0`)
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	t.Parallel()
+
+	testError := errors.New("test")
+	err := error(&PanicError{Value: testError})
+	if !errors.Is(err, testError) {
+		t.Error(`!errors.Is(err, testError)`)
+	}
+	if IsPanic(testError) {
+		t.Error(`IsPanic(testError)`)
+	}
+}
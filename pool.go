@@ -0,0 +1,138 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrPoolClosed is returned when a task is submitted to a closed
+	// WorkerPool.
+	ErrPoolClosed = errors.New("worker pool is closed")
+
+	// ErrPoolFull is returned by WorkerPool.TrySubmit when the queue
+	// of the pool is full.
+	ErrPoolFull = errors.New("worker pool is full")
+)
+
+// WorkerPool runs submitted tasks in a fixed number of goroutines
+// managed by an Environment.
+//
+// The pool is closed when the environment is stopped or canceled.
+// If the environment is stopped, pending tasks in the queue are
+// executed before the workers exit.  If the environment is canceled,
+// pending tasks are discarded.
+type WorkerPool struct {
+	env   *Environment
+	name  string
+	queue chan func(ctx context.Context) error
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWorkerPool creates a WorkerPool that runs tasks in workers
+// goroutines.  At most queueSize tasks can be pending in the queue.
+//
+// Workers appear in Tasks of e as name.
+//
+// Each task takes a context having a new request tracking ID.
+// If a task returns non-nil error, Cancel is called with the error
+// as with Go.
+func (e *Environment) NewWorkerPool(name string, workers, queueSize int) *WorkerPool {
+	if workers <= 0 {
+		panic("workers must be positive")
+	}
+
+	p := &WorkerPool{
+		env:   e,
+		name:  name,
+		queue: make(chan func(ctx context.Context) error, queueSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		labels := map[string]string{"worker": strconv.Itoa(i)}
+		e.GoWithName(name, labels, p.work)
+	}
+
+	go func() {
+		<-e.stopCh
+		p.mu.Lock()
+		p.closed = true
+		close(p.queue)
+		p.mu.Unlock()
+	}()
+
+	return p
+}
+
+func (p *WorkerPool) work(ctx context.Context) error {
+	for f := range p.queue {
+		if ctx.Err() != nil {
+			// discard pending tasks
+			continue
+		}
+
+		p.env.mu.RLock()
+		recoverPanic := p.env.recoverPanic
+		p.env.mu.RUnlock()
+
+		id := p.env.generator.Generate()
+		t := &Task{Name: p.name, RequestID: id}
+		err := runTask(WithRequestID(ctx, id), t, recoverPanic, f)
+		if err != nil {
+			p.env.cancelBy(t, err)
+		}
+	}
+	return nil
+}
+
+// Submit adds f to the queue of the pool.
+// If the queue is full, Submit blocks until the queue has a room
+// or ctx is canceled.
+//
+// Submit returns ErrPoolClosed if the pool has been closed.
+func (p *WorkerPool) Submit(ctx context.Context, f func(ctx context.Context) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.queue <- f:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.env.stopCh:
+		return ErrPoolClosed
+	}
+}
+
+// TrySubmit adds f to the queue of the pool without blocking.
+//
+// TrySubmit returns ErrPoolFull if the queue is full, or ErrPoolClosed
+// if the pool has been closed.
+func (p *WorkerPool) TrySubmit(f func(ctx context.Context) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.queue <- f:
+		return nil
+	default:
+		return ErrPoolFull
+	}
+}
+
+// QueueLen returns the number of pending tasks in the queue.
+func (p *WorkerPool) QueueLen() int {
+	return len(p.queue)
+}
@@ -0,0 +1,108 @@
+package well
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	pool := env.NewWorkerPool("pool", 3, 100)
+
+	var running, maxRunning, done int32
+	for i := 0; i < 30; i++ {
+		err := pool.Submit(context.Background(), func(ctx context.Context) error {
+			if ctx.Value(RequestIDContextKey) == nil {
+				t.Error(`no request ID`)
+			}
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&done, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.Stop()
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if done != 30 {
+		t.Error(`done != 30`, done)
+	}
+	if maxRunning > 3 {
+		t.Error(`maxRunning > 3`, maxRunning)
+	}
+
+	err = pool.Submit(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	if err != ErrPoolClosed {
+		t.Error(`err != ErrPoolClosed`, err)
+	}
+}
+
+func TestWorkerPoolCancel(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	pool := env.NewWorkerPool("pool", 1, 10)
+
+	blockCh := make(chan struct{})
+	startCh := make(chan struct{})
+	err := pool.TrySubmit(func(ctx context.Context) error {
+		close(startCh)
+		<-blockCh
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-startCh
+
+	var executed int32
+	for i := 0; i < 10; i++ {
+		err := pool.TrySubmit(func(ctx context.Context) error {
+			atomic.AddInt32(&executed, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pool.QueueLen() != 10 {
+		t.Error(`pool.QueueLen() != 10`, pool.QueueLen())
+	}
+
+	err = pool.TrySubmit(func(ctx context.Context) error {
+		return nil
+	})
+	if err != ErrPoolFull {
+		t.Error(`err != ErrPoolFull`, err)
+	}
+
+	env.Cancel(nil)
+	close(blockCh)
+	err = env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executed != 0 {
+		t.Error(`executed != 0`, executed)
+	}
+}
@@ -0,0 +1,88 @@
+package well
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	notReady int32
+	inflight int64
+
+	// gracefulMasters is the number of running Graceful masters.
+	// Masters have no in-flight requests of their own.
+	gracefulMasters int32
+
+	inflightPollInterval = 100 * time.Millisecond
+)
+
+// IsReady returns true if the program is ready to serve requests.
+//
+// The program is ready by default.  It becomes not ready when it
+// receives a signal to stop, so that load balancers can notice that
+// the program is going away during CANCELLATION_DELAY_SECONDS.
+func IsReady() bool {
+	return atomic.LoadInt32(&notReady) == 0
+}
+
+// SetReady sets the readiness state of the program.
+func SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&notReady, 0)
+		return
+	}
+	atomic.StoreInt32(&notReady, 1)
+}
+
+// ReadinessHandler returns an http.Handler that reports the readiness
+// state of the program.  The handler responds with 200 OK if ready,
+// or 503 Service Unavailable if not.
+func ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !IsReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// InflightRequests returns the number of requests being processed
+// by HTTPServer and Server.
+func InflightRequests() int64 {
+	return atomic.LoadInt64(&inflight)
+}
+
+func addInflight(n int64) {
+	atomic.AddInt64(&inflight, n)
+}
+
+// waitDelay sleeps for delay, but returns early when there are
+// no in-flight requests after the half of delay has passed.
+// The program is kept not ready at least for that period so that
+// load balancers can notice it.
+//
+// The master process of Graceful always sleeps for delay because
+// requests are processed by its children.
+func waitDelay(delay time.Duration) {
+	st := time.Now()
+	deadline := st.Add(delay)
+	minDeadline := st.Add(delay / 2)
+	early := atomic.LoadInt32(&gracefulMasters) == 0
+	for {
+		if early && InflightRequests() == 0 && !time.Now().Before(minDeadline) {
+			return
+		}
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return
+		}
+		if remain > inflightPollInterval {
+			remain = inflightPollInterval
+		}
+		time.Sleep(remain)
+	}
+}
@@ -0,0 +1,60 @@
+package well
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	defer SetReady(true)
+
+	h := ReadinessHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Error(`w.Code != http.StatusOK`, w.Code)
+	}
+
+	SetReady(false)
+	if IsReady() {
+		t.Error(`IsReady()`)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Error(`w.Code != http.StatusServiceUnavailable`, w.Code)
+	}
+}
+
+func TestWaitDelay(t *testing.T) {
+	addInflight(1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		addInflight(-1)
+	}()
+
+	st := time.Now()
+	waitDelay(2 * time.Second)
+	elapsed := time.Since(st)
+	if elapsed > 1500*time.Millisecond {
+		t.Error(`waitDelay did not end early`, elapsed)
+	}
+	if elapsed < time.Second {
+		t.Error(`waitDelay should wait for the half of the delay`, elapsed)
+	}
+	if InflightRequests() != 0 {
+		t.Error(`InflightRequests() != 0`)
+	}
+
+	atomic.AddInt32(&gracefulMasters, 1)
+	defer atomic.AddInt32(&gracefulMasters, -1)
+	st = time.Now()
+	waitDelay(500 * time.Millisecond)
+	if time.Since(st) < 500*time.Millisecond {
+		t.Error(`waitDelay should not end early in Graceful masters`)
+	}
+}
@@ -0,0 +1,87 @@
+package well
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+var (
+	reloadSignalOnce sync.Once
+)
+
+type reloadHook struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+// OnReload registers a callback function that is called by Reload.
+//
+// For the global environment, registering a callback also makes the
+// program call Reload on SIGHUP, unless the signal has already been
+// mapped to another action, e.g. by Graceful.
+func (e *Environment) OnReload(name string, f func(ctx context.Context) error) {
+	e.mu.Lock()
+	e.reloadHooks = append(e.reloadHooks, reloadHook{name, f})
+	e.mu.Unlock()
+
+	if e == defaultEnv {
+		reloadSignalOnce.Do(handleReloadSignal)
+	}
+}
+
+// Reload calls the callback functions registered by OnReload
+// in the order of registration.
+//
+// Each callback takes a context having a new request tracking ID.
+// The result of each callback is logged.  Even if a callback fails,
+// the rest of callbacks are called.
+//
+// Reload returns an error that contains all errors returned from
+// the callbacks, or nil.
+func (e *Environment) Reload() error {
+	e.mu.RLock()
+	hooks := e.reloadHooks
+	e.mu.RUnlock()
+
+	var errs []error
+	for _, h := range hooks {
+		ctx := WithRequestID(e.ctx, e.generator.Generate())
+		st := time.Now()
+		err := h.f(ctx)
+
+		fields := FieldsFromContext(ctx)
+		fields[log.FnType] = "reload"
+		fields[log.FnResponseTime] = time.Since(st).Seconds()
+		fields["name"] = h.name
+		if err != nil {
+			fields["error"] = err.Error()
+			log.Error("well: reload", fields)
+			errs = append(errs, &TaskError{Name: h.name, Err: err})
+			continue
+		}
+		log.Info("well: reload", fields)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &joinError{errs}
+}
+
+func handleReloadSignal() {
+	ch, _ := subscribeSignalAction(ActionReload)
+	go func() {
+		for range ch {
+			sdNotify(reloadingStates()...)
+			defaultEnv.Reload()
+			sdNotify("READY=1")
+		}
+	}()
+
+	if reloadSignal != nil {
+		setDefaultSignalIfUnmapped(reloadSignal, ActionReload)
+	}
+}
@@ -0,0 +1,46 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEnvironmentReload(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	if err := env.Reload(); err != nil {
+		t.Error(err)
+	}
+
+	errTest := errors.New("test error")
+	var called []string
+	env.OnReload("first", func(ctx context.Context) error {
+		called = append(called, "first")
+		if ctx.Value(RequestIDContextKey) == nil {
+			t.Error(`ctx.Value(RequestIDContextKey) == nil`)
+		}
+		return errTest
+	})
+	env.OnReload("second", func(ctx context.Context) error {
+		called = append(called, "second")
+		return nil
+	})
+
+	err := env.Reload()
+	if !reflect.DeepEqual(called, []string{"first", "second"}) {
+		t.Error("unexpected order:", called)
+	}
+	if !errors.Is(err, errTest) {
+		t.Error(`!errors.Is(err, errTest)`, err)
+	}
+	var te *TaskError
+	if !errors.As(err, &te) {
+		t.Fatal(`!errors.As(err, &te)`)
+	}
+	if te.Name != "first" {
+		t.Error(`te.Name != "first"`, te.Name)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"os"
+	"syscall"
+)
+
+var reloadSignal os.Signal = syscall.SIGHUP
@@ -0,0 +1,5 @@
+package well
+
+import "os"
+
+var reloadSignal os.Signal
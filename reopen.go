@@ -0,0 +1,156 @@
+package well
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/cybozu-go/log"
+)
+
+var (
+	logFilesMu sync.Mutex
+	logFiles   []*logFile
+)
+
+// logFile is an io.Writer that writes to a file.
+// The file can be reopened to cooperate with log rotation programs.
+type logFile struct {
+	filename string
+
+	mu     sync.Mutex
+	f      *os.File
+	err    error
+	closed bool
+}
+
+// newLogFile opens filename and registers it to be reopened by
+// ActionReopenLogs.
+func newLogFile(filename string) (*logFile, error) {
+	f, err := openAppend(filename)
+	if err != nil {
+		return nil, err
+	}
+	l := &logFile{
+		filename: filename,
+		f:        f,
+	}
+
+	logFilesMu.Lock()
+	logFiles = append(logFiles, l)
+	logFilesMu.Unlock()
+	return l, nil
+}
+
+func openAppend(filename string) (*os.File, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// correct the tail of the file if it does not end with a newline.
+	if size := fi.Size(); size > 0 {
+		var buf [1]byte
+		_, err = f.ReadAt(buf[:], size-1)
+		if err == nil && buf[0] != '\n' {
+			_, err = f.Write([]byte{'\n'})
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Write implements io.Writer.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, os.ErrClosed
+	}
+	if l.err != nil {
+		return 0, errors.New("unusable due to " + l.err.Error())
+	}
+	return l.f.Write(p)
+}
+
+// Reopen closes and reopens the file.
+func (l *logFile) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	if l.f != nil {
+		err := l.f.Close()
+		l.f = nil
+		if err != nil {
+			l.err = err
+			return err
+		}
+	}
+
+	f, err := openAppend(l.filename)
+	if err != nil {
+		l.err = err
+		return err
+	}
+	l.f = f
+	l.err = nil
+	return nil
+}
+
+// Close closes the file and stops reopening it.
+func (l *logFile) Close() error {
+	logFilesMu.Lock()
+	for i, f := range logFiles {
+		if f == l {
+			logFiles = append(logFiles[:i], logFiles[i+1:]...)
+			break
+		}
+	}
+	logFilesMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// reopenLogFiles reopens all log files.
+// Errors are logged, and the last one is returned.
+func reopenLogFiles() error {
+	logFilesMu.Lock()
+	files := append([]*logFile(nil), logFiles...)
+	logFilesMu.Unlock()
+
+	var lastErr error
+	for _, l := range files {
+		err := l.Reopen()
+		if err != nil {
+			// the log file may not be usable, so log to stderr.
+			log.NewLogger().Error("well: failed to reopen log file", map[string]interface{}{
+				"filename":  l.filename,
+				log.FnError: err,
+			})
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -59,13 +59,15 @@ func (s *Server) Serve(l net.Listener) {
 	}
 
 	l = netutil.KeepAliveListener(l)
+	serverStarted(env)
 
 	go func() {
 		<-env.ctx.Done()
 		l.Close()
 	}()
 
-	env.Go(func(ctx context.Context) error {
+	labels := map[string]string{"addr": l.Addr().String()}
+	env.GoInPhase(PhaseStopAccepting, "well.Server", labels, func(ctx context.Context) error {
 		generator := NewIDGenerator()
 		for {
 			conn, err := l.Accept()
@@ -78,11 +80,13 @@ func (s *Server) Serve(l net.Listener) {
 			}
 
 			s.wg.Add(1)
+			addInflight(1)
 			go func() {
 				ctx, cancel := context.WithCancel(ctx)
 				defer func() {
 					cancel()
 					conn.Close()
+					addInflight(-1)
 				}()
 				ctx = WithRequestID(ctx, generator.Generate())
 				s.Handler(ctx, conn)
@@ -0,0 +1,230 @@
+package well
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+// ShutdownPhase is a phase of the shutdown sequence of Environment.
+//
+// When Wait finds the environment stopped or canceled, it executes
+// the phases in order.  The next phase begins only after the current
+// phase has finished or timed out.
+type ShutdownPhase int
+
+// Shutdown phases.
+const (
+	// PhaseStopAccepting is the first phase.
+	// Goroutines started by Go, GoWithID, GoWithName as well as
+	// servers such as Server and HTTPServer run in this phase.
+	// The context for them is canceled when Cancel is called.
+	PhaseStopAccepting ShutdownPhase = iota
+
+	// PhaseDrain is the phase to process remaining requests.
+	PhaseDrain
+
+	// PhaseFlush is the phase to flush buffered data.
+	PhaseFlush
+
+	// PhaseClose is the last phase to close resources.
+	PhaseClose
+)
+
+const numShutdownPhases = int(PhaseClose) + 1
+
+// String returns the name of the phase.
+func (p ShutdownPhase) String() string {
+	switch p {
+	case PhaseStopAccepting:
+		return "stop-accepting"
+	case PhaseDrain:
+		return "drain"
+	case PhaseFlush:
+		return "flush"
+	case PhaseClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+func (p ShutdownPhase) valid() bool {
+	return p >= PhaseStopAccepting && p <= PhaseClose
+}
+
+type shutdownHook struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+type shutdownPhase struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// protected by Environment.mu
+	hooks   []shutdownHook
+	timeout time.Duration
+}
+
+// OnShutdown registers a hook function that is called at the
+// beginning of the given shutdown phase.
+//
+// Hooks in a phase are called in the order of registration.
+// ctx will be canceled when the phase times out.
+// Errors returned from f are logged.
+func (e *Environment) OnShutdown(phase ShutdownPhase, name string, f func(ctx context.Context) error) {
+	if !phase.valid() {
+		panic("invalid shutdown phase")
+	}
+
+	e.mu.Lock()
+	p := &e.phases[phase]
+	p.hooks = append(p.hooks, shutdownHook{name, f})
+	e.mu.Unlock()
+}
+
+// SetPhaseTimeout sets the maximum duration of the given shutdown phase.
+// When the phase times out, Wait gives up waiting for its goroutines
+// and hooks and begins the next phase.
+//
+// For PhaseStopAccepting, the timeout starts when Cancel is called.
+//
+// Zero duration disables timeout, which is the default.
+func (e *Environment) SetPhaseTimeout(phase ShutdownPhase, timeout time.Duration) {
+	if !phase.valid() {
+		panic("invalid shutdown phase")
+	}
+
+	e.mu.Lock()
+	e.phases[phase].timeout = timeout
+	e.mu.Unlock()
+}
+
+// GoInPhase is similar to GoWithName but the goroutine runs until
+// the given shutdown phase begins.  The context passed to f is
+// canceled at the beginning of the phase, and Wait waits for f to
+// return before moving to the next phase.
+func (e *Environment) GoInPhase(phase ShutdownPhase, name string, labels map[string]string, f func(ctx context.Context) error) {
+	if !phase.valid() {
+		panic("invalid shutdown phase")
+	}
+
+	id := e.generator.Generate()
+	t := &Task{
+		Name:      name,
+		Labels:    labels,
+		RequestID: id,
+		Phase:     phase,
+	}
+	e.goTask(t, func(ctx context.Context) error {
+		return f(WithRequestID(ctx, id))
+	})
+}
+
+// shutdown executes the shutdown sequence.
+func (e *Environment) shutdown() {
+	if log.Enabled(log.LvDebug) {
+		log.Debug("well: waiting for all goroutines to complete", nil)
+	}
+
+	for i := range e.phases {
+		phase := ShutdownPhase(i)
+		p := &e.phases[i]
+		if phase != PhaseStopAccepting {
+			p.cancel()
+		}
+
+		e.runPhase(phase)
+
+		if phase == PhaseStopAccepting {
+			e.cancel() // in case no one calls Cancel
+		}
+	}
+}
+
+func (e *Environment) runPhase(phase ShutdownPhase) {
+	p := &e.phases[phase]
+
+	e.mu.RLock()
+	hooks := p.hooks
+	timeout := p.timeout
+	e.mu.RUnlock()
+
+	hookCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for _, h := range hooks {
+			err := h.f(hookCtx)
+			if err != nil {
+				log.Warn("well: shutdown hook failed", map[string]interface{}{
+					"phase":     phase.String(),
+					"name":      h.name,
+					log.FnError: err,
+				})
+			}
+		}
+		p.wg.Wait()
+		close(done)
+	}()
+
+	startTimer := p.ctx.Done()
+	var timeoutCh <-chan time.Time
+
+	ticker := time.NewTicker(waitLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-startTimer:
+			startTimer = nil
+			if timeout > 0 {
+				timer := time.NewTimer(timeout)
+				defer timer.Stop()
+				timeoutCh = timer.C
+			}
+			continue
+		case <-timeoutCh:
+			log.Warn("well: timeout waiting for shutdown phase", map[string]interface{}{
+				"phase": phase.String(),
+				"tasks": e.taskNames(phase),
+			})
+			return
+		case <-ticker.C:
+		}
+
+		log.Warn("well: still waiting for goroutines", map[string]interface{}{
+			"phase": phase.String(),
+			"tasks": e.taskNames(phase),
+		})
+	}
+}
+
+// taskNames returns the names of running goroutines in phase.
+func (e *Environment) taskNames(phase ShutdownPhase) []string {
+	var tasks []Task
+	for _, t := range e.Tasks() {
+		if t.Phase == phase {
+			tasks = append(tasks, t)
+		}
+	}
+	return describeTasks(tasks)
+}
+
+// describeTasks returns the names of tasks for logging.
+// Unnamed goroutines are reported as "(unnamed)".
+func describeTasks(tasks []Task) []string {
+	names := make([]string, len(tasks))
+	for i, t := range tasks {
+		names[i] = t.Name
+		if len(t.Name) == 0 {
+			names[i] = "(unnamed)"
+		}
+	}
+	return names
+}
@@ -0,0 +1,96 @@
+package well
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownPhases(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+
+	env.GoInPhase(PhaseFlush, "flusher", nil, func(ctx context.Context) error {
+		<-ctx.Done()
+		record("flush")
+		return nil
+	})
+	env.GoInPhase(PhaseDrain, "drainer", nil, func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		record("drain")
+		return nil
+	})
+	env.OnShutdown(PhaseClose, "closer", func(ctx context.Context) error {
+		record("close")
+		return nil
+	})
+	env.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		record("stop")
+		return nil
+	})
+
+	env.Cancel(nil)
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"stop", "drain", "flush", "close"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Error(`!reflect.DeepEqual(order, expected)`, order)
+	}
+}
+
+func TestShutdownPhaseTimeout(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+	env.SetPhaseTimeout(PhaseDrain, 50*time.Millisecond)
+
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	env.GoInPhase(PhaseDrain, "stuck", nil, func(ctx context.Context) error {
+		<-blockCh
+		return nil
+	})
+
+	closed := make(chan struct{})
+	env.OnShutdown(PhaseClose, "closer", func(ctx context.Context) error {
+		close(closed)
+		return nil
+	})
+
+	tasks := env.Tasks()
+	if len(tasks) != 1 {
+		t.Fatal(`len(tasks) != 1`)
+	}
+	if tasks[0].Phase != PhaseDrain {
+		t.Error(`tasks[0].Phase != PhaseDrain`)
+	}
+
+	env.Cancel(nil)
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-closed:
+	default:
+		t.Error(`close phase was not executed`)
+	}
+}
@@ -0,0 +1,326 @@
+package well
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+// SignalAction is an action taken by the framework when the program
+// receives a signal.
+type SignalAction int
+
+// Signal actions.
+const (
+	// ActionCancel makes the program not ready (see IsReady) and
+	// cancels the global environment after CANCELLATION_DELAY_SECONDS
+	// or when there are no in-flight requests after the half of the delay.
+	// If the program receives a signal mapped to ActionCancel or
+	// ActionCancelImmediately again, the program exits immediately
+	// unless it is a child process of Graceful.
+	//
+	// This is the default action for SIGINT and SIGTERM.
+	ActionCancel SignalAction = iota + 1
+
+	// ActionCancelImmediately is the same as ActionCancel but cancels
+	// the global environment without delay.
+	ActionCancelImmediately
+
+	// ActionRestart restarts the child processes of Graceful.
+	//
+	// This is the default action for SIGHUP in the master process
+	// of Graceful.
+	ActionRestart
+
+	// ActionReload calls Reload of the global environment.
+	//
+	// This is the default action for SIGHUP if reload callbacks are
+	// registered to the global environment and SIGHUP is not mapped
+	// to other actions.
+	ActionReload
+
+	// ActionReopenLogs reopens the log file.
+	//
+	// This is the default action for SIGUSR1 if the log file is
+	// specified.
+	ActionReopenLogs
+
+	// ActionDump dumps diagnostics of the program.
+	// See EnableDiagnosticsDump.
+	//
+	// This is the default action for SIGUSR2 if diagnostics dump
+	// is enabled.
+	ActionDump
+
+	// ActionUpgrade re-executes the master process of Graceful.
+	// See Graceful for details.
+	//
+	// No signal is mapped to this action by default.
+	ActionUpgrade
+)
+
+// String returns the name of the action.
+func (a SignalAction) String() string {
+	switch a {
+	case ActionCancel:
+		return "cancel"
+	case ActionCancelImmediately:
+		return "cancel-immediately"
+	case ActionRestart:
+		return "restart"
+	case ActionReload:
+		return "reload"
+	case ActionReopenLogs:
+		return "reopen-logs"
+	case ActionDump:
+		return "dump"
+	case ActionUpgrade:
+		return "upgrade"
+	}
+	return "unknown"
+}
+
+type signalEntry struct {
+	action SignalAction
+	f      func(os.Signal)
+	byUser bool
+}
+
+var (
+	sigMu            sync.Mutex
+	sigCh            chan os.Signal // for signals mapped by the user
+	sigDefaultCh     chan os.Signal // for signals mapped by the framework
+	sigEntries       = make(map[os.Signal]signalEntry)
+	sigSubscribers   = make(map[SignalAction][]chan os.Signal)
+	sigDefaultsOff   bool
+	sigCancelStarted bool
+)
+
+// HandleSignal maps sig to action.
+// The mapping replaces the existing one for sig, including the
+// default one installed by the framework.
+func HandleSignal(sig os.Signal, action SignalAction) {
+	setSignal(sig, signalEntry{action: action, byUser: true})
+}
+
+// HandleSignalFunc maps sig to a custom callback f.
+// The mapping replaces the existing one for sig, including the
+// default one installed by the framework.
+//
+// f is called in the goroutine that handles all signals,
+// so it should return quickly.
+func HandleSignalFunc(sig os.Signal, f func(sig os.Signal)) {
+	setSignal(sig, signalEntry{f: f, byUser: true})
+}
+
+// DisableDefaultSignalHandlers removes the signal handlers installed
+// by the framework, such as handlers for SIGINT and SIGTERM.
+// The framework will not install default handlers any longer.
+//
+// Handlers installed by HandleSignal and HandleSignalFunc are kept.
+// Channels registered by signal.Notify outside of the framework are
+// not affected.
+func DisableDefaultSignalHandlers() {
+	sigMu.Lock()
+	defer sigMu.Unlock()
+
+	sigDefaultsOff = true
+	for sig, e := range sigEntries {
+		if !e.byUser {
+			delete(sigEntries, sig)
+		}
+	}
+	if sigDefaultCh != nil {
+		// signals mapped by the user are still notified to sigCh.
+		signal.Stop(sigDefaultCh)
+	}
+}
+
+// setDefaultSignal maps sig to action unless the mapping for sig
+// has been set by the user or default handlers are disabled.
+func setDefaultSignal(sig os.Signal, action SignalAction) {
+	sigMu.Lock()
+	if sigDefaultsOff || sigEntries[sig].byUser {
+		sigMu.Unlock()
+		return
+	}
+	sigMu.Unlock()
+
+	setSignal(sig, signalEntry{action: action})
+}
+
+// setDefaultSignalIfUnmapped maps sig to action only if sig is not
+// mapped to any action.
+func setDefaultSignalIfUnmapped(sig os.Signal, action SignalAction) {
+	sigMu.Lock()
+	_, mapped := sigEntries[sig]
+	if sigDefaultsOff || mapped {
+		sigMu.Unlock()
+		return
+	}
+	sigMu.Unlock()
+
+	setSignal(sig, signalEntry{action: action})
+}
+
+func setSignal(sig os.Signal, e signalEntry) {
+	sigMu.Lock()
+	defer sigMu.Unlock()
+
+	if sigCh == nil {
+		sigCh = make(chan os.Signal, 4)
+		sigDefaultCh = make(chan os.Signal, 4)
+		go dispatchSignals(sigCh, true)
+		go dispatchSignals(sigDefaultCh, false)
+	}
+	sigEntries[sig] = e
+	if e.byUser {
+		signal.Notify(sigCh, sig)
+	} else {
+		signal.Notify(sigDefaultCh, sig)
+	}
+}
+
+// subscribeSignalAction returns a channel to receive signals mapped
+// to action.  Call unsubscribe to stop receiving.
+func subscribeSignalAction(action SignalAction) (ch <-chan os.Signal, unsubscribe func()) {
+	c := make(chan os.Signal, 1)
+
+	sigMu.Lock()
+	sigSubscribers[action] = append(sigSubscribers[action], c)
+	sigMu.Unlock()
+
+	return c, func() {
+		sigMu.Lock()
+		defer sigMu.Unlock()
+
+		subs := sigSubscribers[action]
+		for i, sub := range subs {
+			if sub == c {
+				sigSubscribers[action] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// dispatchSignals runs actions for signals from ch.  byUser tells
+// whether ch is for signals mapped by the user.
+func dispatchSignals(ch <-chan os.Signal, byUser bool) {
+	for s := range ch {
+		sigMu.Lock()
+		e, ok := sigEntries[s]
+		sigMu.Unlock()
+		if !ok {
+			continue
+		}
+		if e.byUser != byUser {
+			// a default mapping replaced by the user is still
+			// notified to sigDefaultCh; sigCh handles it.
+			continue
+		}
+
+		if e.f != nil {
+			e.f(s)
+			continue
+		}
+		runSignalAction(e.action, s)
+	}
+}
+
+func runSignalAction(action SignalAction, s os.Signal) {
+	switch action {
+	case ActionCancel:
+		cancelOnSignal(s, getDelaySecondsFromEnv())
+	case ActionCancelImmediately:
+		cancelOnSignal(s, 0)
+	case ActionReopenLogs:
+		reopenLogFiles()
+	case ActionDump:
+		dumpMu.Lock()
+		dir := dumpDir
+		dumpMu.Unlock()
+
+		err := dumpDiagnostics(defaultEnv, dir)
+		if err != nil {
+			log.Error("well: failed to dump diagnostics", map[string]interface{}{
+				log.FnError: err,
+			})
+		}
+	default:
+		sigMu.Lock()
+		subs := sigSubscribers[action]
+		sigMu.Unlock()
+
+		if len(subs) == 0 {
+			log.Warn("well: no handler for signal", map[string]interface{}{
+				"signal": s.String(),
+				"action": action.String(),
+			})
+			return
+		}
+		for _, sub := range subs {
+			select {
+			case sub <- s:
+			default:
+			}
+		}
+	}
+}
+
+// cancelOnSignal makes the program not ready and sends STOPPING=1 to
+// systemd, then cancels the global environment after delay seconds.  The delay may end early when there
+// are no in-flight requests.  See waitDelay.
+//
+// If the program receives a signal again, or the shutdown does not
+// finish within SHUTDOWN_TIMEOUT_SECONDS, the program exits immediately.
+// Child processes of Graceful ignore the second signal.
+func cancelOnSignal(s os.Signal, delay int) {
+	env := defaultEnv
+
+	if sigCancelStarted {
+		if !isMaster() {
+			// the master of Graceful forwards SIGTERM to children
+			// that may have got the signal from systemd already.
+			log.Warn("well: got signal again; ignored", map[string]interface{}{
+				"signal": s.String(),
+			})
+			return
+		}
+		log.Error("well: got signal again; exiting", map[string]interface{}{
+			"signal":     s.String(),
+			"tasks":      describeTasks(env.Tasks()),
+			"goroutines": string(goroutineStacks()),
+		})
+		osExit(1)
+		return
+	}
+	sigCancelStarted = true
+
+	SetReady(false)
+	notifyStopping()
+
+	log.Warn("well: got signal", map[string]interface{}{
+		"signal": s.String(),
+		"delay":  delay,
+	})
+
+	timeout := getShutdownTimeoutSecondsFromEnv()
+	if timeout > 0 {
+		time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			log.Error("well: shutdown timed out", map[string]interface{}{
+				"timeout": timeout,
+				"tasks":   describeTasks(env.Tasks()),
+			})
+			osExit(1)
+		})
+	}
+
+	go func() {
+		waitDelay(time.Duration(delay) * time.Second)
+		env.Cancel(errSignaled)
+	}()
+}
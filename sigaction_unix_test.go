@@ -0,0 +1,113 @@
+//go:build !windows
+// +build !windows
+
+package well
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSignal(t *testing.T) {
+	sig := syscall.SIGUSR2
+	defer func() {
+		sigMu.Lock()
+		delete(sigEntries, sig)
+		signal.Reset(sig)
+		sigMu.Unlock()
+	}()
+
+	ch, unsubscribe := subscribeSignalAction(ActionReload)
+	defer unsubscribe()
+
+	HandleSignal(sig, ActionReload)
+	syscall.Kill(os.Getpid(), sig)
+	select {
+	case s := <-ch:
+		if s != sig {
+			t.Error(`s != sig`, s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`ActionReload was not notified`)
+	}
+
+	got := make(chan os.Signal, 1)
+	HandleSignalFunc(sig, func(s os.Signal) {
+		got <- s
+	})
+
+	// the default mapping does not override the user's mapping.
+	setDefaultSignal(sig, ActionDump)
+
+	syscall.Kill(os.Getpid(), sig)
+	select {
+	case s := <-got:
+		if s != sig {
+			t.Error(`s != sig`, s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`callback was not called`)
+	}
+
+	select {
+	case <-ch:
+		t.Error(`ActionReload should not be notified`)
+	default:
+	}
+}
+
+func TestCancelOnSignalAgainInChild(t *testing.T) {
+	sigCancelStarted = true
+	masterProcess = false
+	var exited bool
+	osExit = func(int) { exited = true }
+	defer func() {
+		sigCancelStarted = false
+		masterProcess = true
+		osExit = os.Exit
+	}()
+
+	cancelOnSignal(syscall.SIGTERM, 0)
+	if exited {
+		t.Error(`child should not exit on the second signal`)
+	}
+
+	masterProcess = true
+	cancelOnSignal(syscall.SIGTERM, 0)
+	if !exited {
+		t.Error(`master should exit on the second signal`)
+	}
+}
+
+func TestDisableDefaultSignalHandlers(t *testing.T) {
+	sig := syscall.SIGWINCH
+	defer func() {
+		sigMu.Lock()
+		sigDefaultsOff = false
+		sigMu.Unlock()
+	}()
+
+	appCh := make(chan os.Signal, 1)
+	signal.Notify(appCh, sig)
+	defer signal.Stop(appCh)
+
+	setDefaultSignal(sig, ActionReload)
+	DisableDefaultSignalHandlers()
+
+	sigMu.Lock()
+	_, mapped := sigEntries[sig]
+	sigMu.Unlock()
+	if mapped {
+		t.Error(`default mapping should be removed`)
+	}
+
+	syscall.Kill(os.Getpid(), sig)
+	select {
+	case <-appCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`channels of the application should not be reset`)
+	}
+}
@@ -3,9 +3,8 @@ package well
 import (
 	"errors"
 	"os"
-	"os/signal"
+	"runtime"
 	"strconv"
-	"time"
 
 	"github.com/cybozu-go/log"
 )
@@ -14,54 +13,72 @@ var (
 	errSignaled = errors.New("signaled")
 
 	cancellationDelaySecondsEnv = "CANCELLATION_DELAY_SECONDS"
+	shutdownTimeoutSecondsEnv   = "SHUTDOWN_TIMEOUT_SECONDS"
 
 	defaultCancellationDelaySeconds = 5
+	defaultShutdownTimeoutSeconds   = 0
+
+	// for testing
+	osExit = os.Exit
 )
 
 // IsSignaled returns true if err returned by Wait indicates that
 // the program has received SIGINT or SIGTERM.
 func IsSignaled(err error) bool {
-	return err == errSignaled
+	return errors.Is(err, errSignaled)
 }
 
-// handleSignal runs independent goroutine to cancel an environment.
-func handleSignal(env *Environment) {
-	ch := make(chan os.Signal, 2)
-	signal.Notify(ch, stopSignals...)
+// handleSignal installs the default handlers for stop signals.
+// See cancelOnSignal.
+func handleSignal() {
+	for _, sig := range stopSignals {
+		setDefaultSignal(sig, ActionCancel)
+	}
+}
 
-	go func() {
-		s := <-ch
-		delay := getDelaySecondsFromEnv()
-		log.Warn("well: got signal", map[string]interface{}{
-			"signal": s.String(),
-			"delay":  delay,
-		})
-		time.Sleep(time.Duration(delay) * time.Second)
-		env.Cancel(errSignaled)
-	}()
+// goroutineStacks returns the stack traces of all goroutines.
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
 }
 
 func getDelaySecondsFromEnv() int {
-	delayStr := os.Getenv(cancellationDelaySecondsEnv)
-	if len(delayStr) == 0 {
-		return defaultCancellationDelaySeconds
+	return getSecondsFromEnv(cancellationDelaySecondsEnv, "cancellation delay", "delay", defaultCancellationDelaySeconds)
+}
+
+func getShutdownTimeoutSecondsFromEnv() int {
+	return getSecondsFromEnv(shutdownTimeoutSecondsEnv, "shutdown timeout", "timeout", defaultShutdownTimeoutSeconds)
+}
+
+// getSecondsFromEnv reads seconds from environment variable name.
+// desc and field are used to log invalid values.
+func getSecondsFromEnv(name, desc, field string, defaultSeconds int) int {
+	secStr := os.Getenv(name)
+	if len(secStr) == 0 {
+		return defaultSeconds
 	}
 
-	delay, err := strconv.Atoi(delayStr)
+	sec, err := strconv.Atoi(secStr)
 	if err != nil {
-		log.Warn("well: set default cancellation delay seconds", map[string]interface{}{
-			"env":       delayStr,
-			"delay":     defaultCancellationDelaySeconds,
+		log.Warn("well: set default "+desc+" seconds", map[string]interface{}{
+			"env":       secStr,
+			field:       defaultSeconds,
 			log.FnError: err,
 		})
-		return defaultCancellationDelaySeconds
+		return defaultSeconds
 	}
-	if delay < 0 {
-		log.Warn("well: round up negative cancellation delay seconds to 0s", map[string]interface{}{
-			"env":   delayStr,
-			"delay": 0,
+	if sec < 0 {
+		log.Warn("well: round up negative "+desc+" seconds to 0s", map[string]interface{}{
+			"env": secStr,
+			field: 0,
 		})
 		return 0
 	}
-	return delay
+	return sec
 }
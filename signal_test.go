@@ -0,0 +1,26 @@
+package well
+
+import "testing"
+
+func TestGetSecondsFromEnv(t *testing.T) {
+	const name = "WELL_TEST_SECONDS"
+
+	cases := []struct {
+		value    string
+		expected int
+	}{
+		{"", 3},
+		{"10", 10},
+		{"0", 0},
+		{"-1", 0},
+		{"abc", 3},
+	}
+
+	for _, c := range cases {
+		t.Setenv(name, c.value)
+		sec := getSecondsFromEnv(name, "test", "seconds", 3)
+		if sec != c.expected {
+			t.Error(c.value, `sec != c.expected`, sec)
+		}
+	}
+}
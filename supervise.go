@@ -0,0 +1,227 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter randomizes d in the range of [d*(1-ratio), d*(1+ratio)].
+func jitter(d time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 || d <= 0 {
+		return d
+	}
+	jitterMu.Lock()
+	r := jitterRand.Float64()
+	jitterMu.Unlock()
+	return time.Duration(float64(d) * (1 + ratio*(2*r-1)))
+}
+
+// randomDuration returns a random duration in [0, max).
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
+
+// RestartMode specifies when a supervised function is restarted.
+type RestartMode int
+
+// Restart modes.
+const (
+	// RestartOnFailure restarts the function only if it returns
+	// non-nil error.
+	RestartOnFailure RestartMode = iota
+
+	// RestartAlways restarts the function whenever it returns.
+	RestartAlways
+)
+
+// RestartPolicy is a policy to restart a function.
+type RestartPolicy struct {
+	// Mode specifies when the function is restarted.
+	Mode RestartMode
+
+	// MaxRestarts is the maximum number of restarts allowed within
+	// Window.  Once exceeded, the policy is exhausted.
+	//
+	// Zero means unlimited.
+	MaxRestarts int
+
+	// Window is the time window to count restarts for MaxRestarts.
+	//
+	// Zero counts all restarts.
+	Window time.Duration
+
+	// InitialBackoff is the delay before the first restart.
+	//
+	// Zero means 100 milliseconds.  In that case, zero MaxBackoff
+	// means 10 seconds so that a function failing repeatedly does not
+	// consume CPU nor flood logs.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between restarts.
+	// The delay is reset to InitialBackoff when the function runs
+	// longer than MaxBackoff.  If MaxBackoff is zero, the delay is
+	// reset when the function runs longer than the current delay.
+	//
+	// Zero means no limit.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor to increase the delay for each
+	// consecutive restart.
+	//
+	// Zero is treated as 2.
+	Multiplier float64
+
+	// Jitter randomizes the delay by the given ratio.
+	// For example, 0.1 randomizes the delay within +-10%.
+	Jitter float64
+}
+
+// restarter keeps track of restarts according to a RestartPolicy.
+type restarter struct {
+	policy   RestartPolicy
+	history  []time.Time
+	delay    time.Duration
+	restarts int
+}
+
+func newRestarter(policy RestartPolicy) *restarter {
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultInitialBackoff
+		if policy.MaxBackoff == 0 {
+			policy.MaxBackoff = defaultMaxBackoff
+		}
+	}
+	return &restarter{
+		policy: policy,
+		delay:  policy.InitialBackoff,
+	}
+}
+
+// next returns the delay before the next restart.
+// st is the time when the last run started.
+// ok is false if the policy has been exhausted.
+func (r *restarter) next(st, now time.Time) (delay time.Duration, ok bool) {
+	p := r.policy
+
+	if p.MaxRestarts > 0 {
+		if p.Window > 0 {
+			var i int
+			for i < len(r.history) && now.Sub(r.history[i]) > p.Window {
+				i++
+			}
+			r.history = r.history[i:]
+		}
+		if len(r.history) >= p.MaxRestarts {
+			return 0, false
+		}
+		r.history = append(r.history, now)
+	}
+
+	healthy := p.MaxBackoff
+	if healthy <= 0 {
+		healthy = r.delay
+	}
+	if now.Sub(st) > healthy {
+		r.delay = p.InitialBackoff
+	}
+
+	delay = r.delay
+
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	r.delay = time.Duration(float64(r.delay) * multiplier)
+	if p.MaxBackoff > 0 && r.delay > p.MaxBackoff {
+		r.delay = p.MaxBackoff
+	}
+
+	r.restarts++
+	return jitter(delay, p.Jitter), true
+}
+
+// Supervise starts a named goroutine that executes f and restarts it
+// according to policy.
+//
+// Each run of f takes a context having a new request tracking ID.
+// Restarts are logged with the error returned from f, if any.
+//
+// Cancel is called only when the policy is exhausted.  In that case,
+// the error returned from the last run of f is passed to Cancel.
+//
+// Supervise stops restarting f when the environment is canceled.
+func (e *Environment) Supervise(name string, policy RestartPolicy, f func(ctx context.Context) error) {
+	e.GoWithName(name, nil, func(ctx context.Context) error {
+		r := newRestarter(policy)
+		for {
+			e.mu.RLock()
+			recoverPanic := e.recoverPanic
+			e.mu.RUnlock()
+
+			id := e.generator.Generate()
+			t := &Task{Name: name, RequestID: id}
+			st := time.Now()
+			err := runTask(WithRequestID(ctx, id), t, recoverPanic, f)
+			if ctx.Err() != nil {
+				return err
+			}
+			if err == nil && policy.Mode != RestartAlways {
+				return nil
+			}
+
+			fields := map[string]interface{}{
+				log.FnType:         "supervise",
+				log.FnResponseTime: time.Since(st).Seconds(),
+				log.FnStartAt:      st,
+				log.FnRequestID:    id,
+				"name":             name,
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+
+			delay, ok := r.next(st, time.Now())
+			if !ok {
+				log.Error("well: restart limit exceeded", fields)
+				if err == nil {
+					err = errors.New("well: " + name + ": restart limit exceeded")
+				}
+				return err
+			}
+
+			fields["restarts"] = r.restarts
+			fields["delay"] = delay.Seconds()
+			if err != nil {
+				log.Error("well: restart", fields)
+			} else {
+				log.Info("well: restart", fields)
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuperviseOnFailure(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	var count int
+	env.Supervise("test", RestartPolicy{}, func(ctx context.Context) error {
+		count++
+		if count < 3 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+
+	env.Stop()
+	err := env.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Error(`count != 3`, count)
+	}
+}
+
+func TestSuperviseDefaultBackoff(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	var count int32
+	env.Supervise("test", RestartPolicy{}, func(ctx context.Context) error {
+		atomic.AddInt32(&count, 1)
+		return errors.New("fail")
+	})
+
+	time.Sleep(350 * time.Millisecond)
+	env.Cancel(nil)
+	env.Wait()
+
+	// runs at 0ms, 100ms, and 300ms.
+	n := atomic.LoadInt32(&count)
+	if n < 2 || n > 4 {
+		t.Error(`failing function should be restarted with backoff`, n)
+	}
+}
+
+func TestRestarterDefaultBackoff(t *testing.T) {
+	t.Parallel()
+
+	r := newRestarter(RestartPolicy{})
+	now := time.Now()
+	delay, _ := r.next(now, now)
+	if delay != defaultInitialBackoff {
+		t.Error(`delay != defaultInitialBackoff`, delay)
+	}
+	for i := 0; i < 20; i++ {
+		delay, _ = r.next(now, now)
+	}
+	if delay != defaultMaxBackoff {
+		t.Error(`delay != defaultMaxBackoff`, delay)
+	}
+}
+
+func TestSuperviseExhausted(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvironment(context.Background())
+
+	testError := errors.New("test")
+	var count int
+	policy := RestartPolicy{
+		Mode:           RestartAlways,
+		MaxRestarts:    2,
+		Window:         time.Minute,
+		InitialBackoff: time.Millisecond,
+	}
+	env.Supervise("test", policy, func(ctx context.Context) error {
+		count++
+		return testError
+	})
+
+	err := env.Wait()
+	if err != testError {
+		t.Error(`err != testError`, err)
+	}
+	if count != 3 {
+		t.Error(`count != 3`, count)
+	}
+}
+
+func TestRestarterBackoff(t *testing.T) {
+	t.Parallel()
+
+	r := newRestarter(RestartPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     30 * time.Millisecond,
+	})
+
+	now := time.Now()
+	expected := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		30 * time.Millisecond,
+		30 * time.Millisecond,
+	}
+	for i, e := range expected {
+		delay, ok := r.next(now, now)
+		if !ok {
+			t.Fatal(`!ok`)
+		}
+		if delay != e {
+			t.Error(i, `delay != e`, delay)
+		}
+	}
+
+	// the delay is reset after a long run.
+	delay, _ := r.next(now.Add(-time.Second), now)
+	if delay != 10*time.Millisecond {
+		t.Error(`delay != 10*time.Millisecond`, delay)
+	}
+}
+
+func TestRestarterResetWithoutMaxBackoff(t *testing.T) {
+	t.Parallel()
+
+	r := newRestarter(RestartPolicy{
+		InitialBackoff: time.Second,
+	})
+
+	now := time.Now()
+	var delay time.Duration
+	for i := 0; i < 15; i++ {
+		delay, _ = r.next(now, now)
+	}
+	if delay != time.Second<<14 {
+		t.Fatal(`delay != time.Second<<14`, delay)
+	}
+
+	// the delay is reset after a healthy run of days.
+	delay, _ = r.next(now.Add(-72*time.Hour), now)
+	if delay != time.Second {
+		t.Error(`delay != time.Second`, delay)
+	}
+	delay, _ = r.next(now, now)
+	if delay != 2*time.Second {
+		t.Error(`delay != 2*time.Second`, delay)
+	}
+}
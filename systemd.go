@@ -2,9 +2,15 @@ package well
 
 import (
 	"bufio"
+	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cybozu-go/log"
 )
 
 // IsSystemdService returns true if the program runs as a systemd service.
@@ -43,3 +49,122 @@ func IsSystemdService() bool {
 
 	return isService
 }
+
+// SystemdNotify sends states to the service manager if NOTIFY_SOCKET
+// environment variable is set.  Multiple states are sent in a message.
+// This does nothing if NOTIFY_SOCKET is not set.
+//
+// Examples of states are "READY=1", "RELOADING=1", and "STOPPING=1".
+// For details, see https://www.freedesktop.org/software/systemd/man/sd_notify.html
+//
+// The framework sends some states automatically:
+//   - READY=1 when NotifyReady is called in programs without Graceful,
+//     or when all child processes of Graceful become ready.
+//     Programs without Graceful need to call NotifyReady by themselves,
+//     or EnableAutoReady to send it from Wait.
+//   - RELOADING=1 when reloading configurations or restarting
+//     child processes of Graceful by SIGHUP, then READY=1 when done.
+//   - STOPPING=1 when the global environment is canceled, except for
+//     the master process of Graceful that has been upgraded.
+//   - MAINPID= when the master process of Graceful is upgraded.
+func SystemdNotify(states ...string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if len(addr) == 0 {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// SystemdStatus sends a free-form status string to the service manager.
+// The status is shown by "systemctl status".
+func SystemdStatus(status string) error {
+	return SystemdNotify("STATUS=" + status)
+}
+
+var (
+	stoppingOnce sync.Once
+
+	autoReady      int32
+	autoReadyOnce  sync.Once
+	serversStarted int32
+
+	// mainPIDHandedOver is set when the master process of Graceful
+	// has passed MAINPID to a new master.
+	mainPIDHandedOver int32
+)
+
+// EnableAutoReady makes Wait send READY=1 to systemd if HTTPServer,
+// Server, or PacketServer has been started in the global environment.
+// Start all servers before calling Wait.
+//
+// This is for programs without Graceful.  The master process of
+// Graceful sends READY=1 when its children become ready.
+func EnableAutoReady() {
+	atomic.StoreInt32(&autoReady, 1)
+}
+
+// serverStarted records that a server has been started in env.
+func serverStarted(env *Environment) {
+	if env == defaultEnv {
+		atomic.StoreInt32(&serversStarted, 1)
+	}
+}
+
+// notifyAutoReady sends READY=1 once if enabled by EnableAutoReady
+// and servers have been started in the global environment.
+func notifyAutoReady() {
+	if atomic.LoadInt32(&autoReady) == 0 || atomic.LoadInt32(&serversStarted) == 0 {
+		return
+	}
+	if !isMaster() {
+		// children of Graceful notify the master by NotifyReady.
+		return
+	}
+	autoReadyOnce.Do(func() {
+		sdNotify("READY=1")
+	})
+}
+
+// notifyStopping sends STOPPING=1 once from the main process.
+// Nothing is sent after MAINPID is handed over to a new master
+// because systemd would stop the new master.
+func notifyStopping() {
+	if !isMaster() || atomic.LoadInt32(&mainPIDHandedOver) != 0 {
+		return
+	}
+	stoppingOnce.Do(func() {
+		sdNotify("STOPPING=1", "STATUS=Stopping")
+	})
+}
+
+// reloadingStates returns RELOADING=1 with MONOTONIC_USEC that is
+// required for services of Type=notify-reload, and states.
+func reloadingStates(states ...string) []string {
+	s := []string{"RELOADING=1"}
+	if usec := monotonicUsec(); usec > 0 {
+		s = append(s, "MONOTONIC_USEC="+strconv.FormatInt(usec, 10))
+	}
+	return append(s, states...)
+}
+
+// sdNotify sends states and logs errors, if any.
+func sdNotify(states ...string) {
+	err := SystemdNotify(states...)
+	if err != nil {
+		log.Warn("well: failed to notify systemd", map[string]interface{}{
+			"states":    states,
+			log.FnError: err.Error(),
+		})
+	}
+}
@@ -1,9 +1,18 @@
 package well
 
 import (
+	"context"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestIsSystemdService(t *testing.T) {
@@ -36,3 +45,177 @@ func TestIsSystemdService(t *testing.T) {
 		t.Error(`!IsSystemdService()`)
 	}
 }
+
+func TestSdNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows does not support unixgram")
+	}
+
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", addr)
+	read := func() string {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	err = SystemdNotify("STOPPING=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg != "STOPPING=1" {
+		t.Error(`msg != "STOPPING=1"`, msg)
+	}
+
+	err = SystemdNotify("RELOADING=1", "STATUS=reloading")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg != "RELOADING=1\nSTATUS=reloading" {
+		t.Error(`msg != "RELOADING=1\nSTATUS=reloading"`, msg)
+	}
+
+	err = SystemdStatus("running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg != "STATUS=running" {
+		t.Error(`msg != "STATUS=running"`, msg)
+	}
+
+	err = NotifyReady()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg != "READY=1" {
+		t.Error(`msg != "READY=1"`, msg)
+	}
+}
+
+func TestReloadingStates(t *testing.T) {
+	t.Parallel()
+
+	states := reloadingStates("STATUS=reloading")
+	if runtime.GOOS != "linux" {
+		if !reflect.DeepEqual(states, []string{"RELOADING=1", "STATUS=reloading"}) {
+			t.Error(`unexpected states`, states)
+		}
+		return
+	}
+	if len(states) != 3 || states[0] != "RELOADING=1" || states[2] != "STATUS=reloading" {
+		t.Fatal(`unexpected states`, states)
+	}
+	usec, err := strconv.ParseInt(strings.TrimPrefix(states[1], "MONOTONIC_USEC="), 10, 64)
+	if err != nil || usec <= 0 {
+		t.Error(`invalid MONOTONIC_USEC`, states[1])
+	}
+}
+
+func TestNotifyStopping(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows does not support unixgram")
+	}
+
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr)
+
+	// other tests or previous runs may have sent STOPPING=1.
+	stoppingOnce = sync.Once{}
+	defer func() {
+		stoppingOnce = sync.Once{}
+	}()
+
+	buf := make([]byte, 1024)
+
+	// nothing is sent after the handover of MAINPID.
+	atomic.StoreInt32(&mainPIDHandedOver, 1)
+	notifyStopping()
+	atomic.StoreInt32(&mainPIDHandedOver, 0)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(buf); err == nil {
+		t.Error(`STOPPING=1 should not be sent after handover`)
+	}
+
+	notifyStopping()
+	notifyStopping()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); msg != "STOPPING=1\nSTATUS=Stopping" {
+		t.Error(`unexpected message`, msg)
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(buf); err == nil {
+		t.Error(`STOPPING=1 should be sent only once`)
+	}
+}
+
+func TestNotifyAutoReady(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows does not support unixgram")
+	}
+
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr)
+
+	reset := func() {
+		atomic.StoreInt32(&autoReady, 0)
+		atomic.StoreInt32(&serversStarted, 0)
+		autoReadyOnce = sync.Once{}
+	}
+	reset()
+	defer reset()
+
+	buf := make([]byte, 1024)
+	notReceived := func(msg string) {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := conn.Read(buf); err == nil {
+			t.Error(msg)
+		}
+	}
+
+	serverStarted(defaultEnv)
+	notifyAutoReady()
+	notReceived(`READY=1 should not be sent unless enabled`)
+
+	atomic.StoreInt32(&serversStarted, 0)
+	EnableAutoReady()
+	serverStarted(NewEnvironment(context.Background()))
+	notifyAutoReady()
+	notReceived(`READY=1 should not be sent without servers in the global environment`)
+
+	serverStarted(defaultEnv)
+	notifyAutoReady()
+	notifyAutoReady()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); msg != "READY=1" {
+		t.Error(`unexpected message`, msg)
+	}
+	notReceived(`READY=1 should be sent only once`)
+}
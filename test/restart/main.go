@@ -16,6 +16,12 @@ import (
 	"github.com/cybozu-go/well"
 )
 
+const (
+	// unixAddrEnv passes the path of the unix socket to a new master
+	// started by upgrade.
+	unixAddrEnv = "RESTART_TEST_UNIX_ADDR"
+)
+
 var (
 	tcpAddr  = "localhost:18556"
 	unixAddr string
@@ -41,8 +47,12 @@ func main() {
 		log.Info("not a systemd service", nil)
 	}
 
-	unixAddr = getTemporaryFilename()
-	defer os.Remove(unixAddr)
+	unixAddr = os.Getenv(unixAddrEnv)
+	if len(unixAddr) == 0 {
+		unixAddr = getTemporaryFilename()
+		os.Setenv(unixAddrEnv, unixAddr)
+	}
+	upgraded := isUpgraded()
 
 	listen := func() ([]net.Listener, error) {
 		ln1, err := net.Listen("tcp", tcpAddr)
@@ -62,16 +72,33 @@ func main() {
 	}
 
 	g := &well.Graceful{
-		Listen: listen,
-		Serve:  serve,
+		Listen:        listen,
+		Serve:         serve,
+		ManualReady:   true,
+		ReadyTimeout:  3 * time.Second,
+		ControlSocket: controlSocket(),
 	}
+	setupUpgrade()
 	g.Run()
 
 	// rest are executed only in the master process.
+	if upgraded {
+		well.Go(testUpgraded)
+	}
 	err := well.Wait()
 	if err != nil && !well.IsSignaled(err) {
+		os.Remove(unixAddr)
 		log.ErrorExit(err)
 	}
+
+	// the new master takes over the unix socket after upgrade.
+	ok, err := waitUpgraded()
+	if err != nil {
+		log.ErrorExit(err)
+	}
+	if !ok {
+		os.Remove(unixAddr)
+	}
 }
 
 // serve implements a network server that can be stopped gracefully
@@ -96,6 +123,9 @@ func serve(listeners []net.Listener) {
 	for _, ln := range listeners {
 		s.Serve(ln)
 	}
+	if !notReady() {
+		well.NotifyReady()
+	}
 	err := well.Wait()
 	if err != nil && !well.IsSignaled(err) {
 		log.ErrorExit(err)
@@ -111,6 +141,11 @@ func testClient(ctx context.Context) error {
 		restart()
 	}
 
+	err := testNotReady(ctx)
+	if err != nil {
+		return err
+	}
+
 	if runtime.GOOS != "windows" {
 		err := ping("unix", unixAddr)
 		if err != nil {
@@ -118,6 +153,12 @@ func testClient(ctx context.Context) error {
 		}
 	}
 
+	// the new master cancels itself after tests.
+	ok, err := upgrade(ctx)
+	if err != nil || ok {
+		return err
+	}
+
 	well.Cancel(nil)
 	return nil
 }
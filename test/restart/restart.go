@@ -4,12 +4,175 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/cybozu-go/log"
+	"github.com/cybozu-go/well"
+)
+
+const (
+	// notReadyEnv makes new children never notify readiness.
+	notReadyEnv = "RESTART_TEST_NOT_READY"
+
+	// oldPidsEnv passes the children of the old master to a new master
+	// started by upgrade.
+	oldPidsEnv = "RESTART_TEST_OLD_PIDS"
 )
 
+// upgradeStarted is set when the master has been upgraded by upgrade.
+var upgradeStarted bool
+
 func restart() {
 	syscall.Kill(os.Getpid(), syscall.SIGHUP)
 	time.Sleep(100 * time.Millisecond)
 }
+
+func controlSocket() string {
+	return unixAddr + ".control"
+}
+
+func resultFile() string {
+	return unixAddr + ".result"
+}
+
+func setupUpgrade() {
+	well.HandleSignal(syscall.SIGUSR2, well.ActionUpgrade)
+}
+
+func isUpgraded() bool {
+	return len(os.Getenv(oldPidsEnv)) > 0
+}
+
+func notReady() bool {
+	return len(os.Getenv(notReadyEnv)) > 0
+}
+
+// testNotReady tests that the old child keeps running when a new child
+// does not become ready.  The old child is tested by the next ping.
+func testNotReady(ctx context.Context) error {
+	client := &well.ControlClient{Path: controlSocket()}
+	before, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	os.Setenv(notReadyEnv, "1")
+	after, err := client.Restart(ctx)
+	os.Unsetenv(notReadyEnv)
+	if err == nil {
+		return errors.New("restart should fail if the new child is not ready")
+	}
+	log.Info("restart failed as expected", map[string]interface{}{
+		log.FnError: err.Error(),
+	})
+	if after == nil {
+		return errors.New("no status after failed restart")
+	}
+	if len(after.Children) != 1 || after.Children[0].PID != before.Children[0].PID {
+		return errors.New("old child should keep running")
+	}
+	return nil
+}
+
+// upgrade starts a new master.  The new master runs testUpgraded.
+func upgrade(ctx context.Context) (bool, error) {
+	client := &well.ControlClient{Path: controlSocket()}
+	st, err := client.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	var pids []string
+	for _, c := range st.Children {
+		pids = append(pids, strconv.Itoa(c.PID))
+	}
+	os.Setenv(oldPidsEnv, strings.Join(pids, ","))
+	os.Remove(resultFile())
+
+	upgradeStarted = true
+	return true, syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+}
+
+// testUpgraded tests the servers in a new master started by upgrade.
+// The result is written to resultFile for the old master.
+func testUpgraded(ctx context.Context) error {
+	err := doTestUpgraded(ctx)
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	tmp := resultFile() + ".tmp"
+	werr := os.WriteFile(tmp, []byte(result), 0644)
+	if werr == nil {
+		werr = os.Rename(tmp, resultFile())
+	}
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		return err
+	}
+
+	well.Cancel(nil)
+	return nil
+}
+
+func doTestUpgraded(ctx context.Context) error {
+	// wait for the children of the old master to exit.
+	for _, v := range strings.Split(os.Getenv(oldPidsEnv), ",") {
+		pid, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		for syscall.Kill(pid, 0) == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	client := &well.ControlClient{Path: controlSocket()}
+	st, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.PID != os.Getpid() {
+		return errors.New("control socket is not taken over")
+	}
+
+	err = ping("tcp4", tcpAddr)
+	if err != nil {
+		return err
+	}
+	restart()
+	return ping("unix", unixAddr)
+}
+
+// waitUpgraded waits for the new master to finish testUpgraded
+// if this master has been upgraded.
+func waitUpgraded() (bool, error) {
+	if !upgradeStarted {
+		return false, nil
+	}
+	defer os.Remove(resultFile())
+
+	for i := 0; i < 600; i++ {
+		data, err := os.ReadFile(resultFile())
+		if err == nil {
+			if string(data) != "ok" {
+				return true, errors.New("upgraded master failed: " + string(data))
+			}
+			log.Info("upgraded master succeeded", nil)
+			return true, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true, errors.New("timeout waiting for upgraded master")
+}
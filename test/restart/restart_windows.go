@@ -3,8 +3,41 @@
 
 package main
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 func restart() {
 	time.Sleep(10 * time.Millisecond)
 }
+
+func controlSocket() string {
+	return ""
+}
+
+func setupUpgrade() {}
+
+func isUpgraded() bool {
+	return false
+}
+
+func notReady() bool {
+	return false
+}
+
+func testNotReady(ctx context.Context) error {
+	return nil
+}
+
+func upgrade(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func testUpgraded(ctx context.Context) error {
+	return nil
+}
+
+func waitUpgraded() (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,113 @@
+package well
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybozu-go/log"
+)
+
+// watchdogInterval returns the interval to send keepalives to systemd
+// watchdog, or zero if the watchdog is not enabled for this process.
+//
+// https://www.freedesktop.org/software/systemd/man/sd_watchdog_enabled.html
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if v := os.Getenv("WATCHDOG_PID"); len(v) > 0 {
+		pid, err := strconv.Atoi(v)
+		if err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+
+	// send keepalives at the half of the timeout as recommended.
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// watchdogEnviron returns environ for a new master started by
+// ActionUpgrade.  WATCHDOG_PID is removed because systemd set it to
+// the pid of this process, and the new master's pid is not known
+// until it is started.  Without WATCHDOG_PID, the new master regards
+// the watchdog as enabled for itself.  If the watchdog is not enabled
+// for this process, WATCHDOG_USEC is removed as well.
+func watchdogEnviron(environ []string) []string {
+	enabled := watchdogInterval() > 0
+	ret := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			continue
+		}
+		if !enabled && strings.HasPrefix(kv, "WATCHDOG_USEC=") {
+			continue
+		}
+		ret = append(ret, kv)
+	}
+	return ret
+}
+
+func handleWatchdog() {
+	if !isMaster() {
+		return
+	}
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go runWatchdog(context.Background(), defaultEnv, interval)
+}
+
+// runWatchdog sends WATCHDOG=1 every interval while health checks
+// of env pass.  If health checks do not finish within interval,
+// the keepalive is skipped.
+func runWatchdog(ctx context.Context, env *Environment, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var done chan error
+	for {
+		if done == nil {
+			done = make(chan error, 1)
+			go func(done chan<- error) {
+				ctx, cancel := context.WithTimeout(ctx, interval)
+				defer cancel()
+				done <- env.CheckHealth(ctx)
+			}(done)
+		}
+
+		select {
+		case err := <-done:
+			done = nil
+			if err != nil {
+				log.Error("well: health check failed", map[string]interface{}{
+					log.FnError: err.Error(),
+				})
+				break
+			}
+			if atomic.LoadInt32(&mainPIDHandedOver) != 0 {
+				// the new master sends keepalives.
+				return
+			}
+			sdNotify("WATCHDOG=1")
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Error("well: health check timed out", map[string]interface{}{
+				"timeout": interval.Seconds(),
+			})
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package well
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if watchdogInterval() != 0 {
+		t.Error(`watchdogInterval() != 0`)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	if d := watchdogInterval(); d != time.Second {
+		t.Error(`d != time.Second`, d)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d := watchdogInterval(); d != time.Second {
+		t.Error(`d != time.Second`, d)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if watchdogInterval() != 0 {
+		t.Error(`watchdogInterval() != 0`)
+	}
+}
+
+func TestWatchdogEnviron(t *testing.T) {
+	environ := []string{"FOO=bar", "WATCHDOG_USEC=2000000", "WATCHDOG_PID=" + strconv.Itoa(os.Getpid())}
+
+	// the old master started by systemd.
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	newEnv := watchdogEnviron(environ)
+	if !reflect.DeepEqual(newEnv, []string{"FOO=bar", "WATCHDOG_USEC=2000000"}) {
+		t.Fatal(`unexpected environ`, newEnv)
+	}
+
+	// the new master, whose pid differs from the original WATCHDOG_PID.
+	os.Unsetenv("WATCHDOG_PID")
+	if d := watchdogInterval(); d != time.Second {
+		t.Error(`watchdog should be enabled in the new master`, d)
+	}
+
+	// the watchdog is passed on again by the next upgrade.
+	newEnv = watchdogEnviron(newEnv)
+	if !reflect.DeepEqual(newEnv, []string{"FOO=bar", "WATCHDOG_USEC=2000000"}) {
+		t.Error(`unexpected environ`, newEnv)
+	}
+
+	// the watchdog is not for this process.
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	newEnv = watchdogEnviron(environ)
+	if !reflect.DeepEqual(newEnv, []string{"FOO=bar"}) {
+		t.Error(`unexpected environ`, newEnv)
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows does not support unixgram")
+	}
+
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr)
+
+	var healthy int32 = 1
+	env := NewEnvironment(context.Background())
+	env.AddHealthCheck("flag", func(ctx context.Context) error {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("unhealthy")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runWatchdog(ctx, env, 50*time.Millisecond)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "WATCHDOG=1" {
+		t.Error(`string(buf[:n]) != "WATCHDOG=1"`, string(buf[:n]))
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(100 * time.Millisecond)
+
+	// drain keepalives sent before the health check failed.
+	for i := 0; i < 3; i++ {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		conn.Read(buf)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err = conn.Read(buf)
+	if err == nil {
+		t.Error("keepalive should not be sent while unhealthy:", string(buf[:n]))
+	}
+}